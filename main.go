@@ -2,31 +2,136 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/nsf/termbox-go"
 	"github.com/rhcarvalho/redgreen/redgreen"
+	"github.com/rhcarvalho/redgreen/sound"
 )
 
 // Command-line flags and arguments.
 var (
-	testCommand = []string{"go", "test"}
-	timeout     time.Duration
-	debug       bool
+	testCommand  = []string{"go", "test"}
+	timeout      time.Duration
+	debounce     time.Duration
+	outputLimit  int
+	restart      bool
+	affected     bool
+	fullEvery    int
+	debug        bool
+	configPath   string
+	watchPaths   stringList
+	recursive    bool
+	ignore       stringList
+	include      stringList
+	gitIgnore    bool
+	contentHash  bool
+	poll         bool
+	pollInterval time.Duration
+	pollHash     bool
+	sessionPath  string
+	resume       bool
+	pilots       commaList
+	rotation     time.Duration
+	mute         bool
+	player       string
+	recoverAfter int
+)
+
+// Settings that can only be set in a configuration file.
+var (
+	commands []redgreen.CommandConfig
+	colors   map[redgreen.Status]redgreen.Color
+	hooks    redgreen.Hooks
+	keymap   = redgreen.DefaultKeymap()
+	sounds   = redgreen.DefaultSounds()
 )
 
 func init() {
 	flag.BoolVar(&debug, "debug", false, "Enable debug mode, disable termbox.")
+	flag.StringVar(&configPath, "config", "", "Configuration file. Defaults to the first of "+strings.Join(redgreen.ConfigFileNames, ", ")+" found in the current directory or its parents.")
+	flag.Var(&watchPaths, "watch", "Path to watch for changes. May be repeated. Defaults to the current directory.")
+	flag.DurationVar(&debounce, "debounce", 200*time.Millisecond, "Time to wait for more changes before running the command.")
+	flag.BoolVar(&recursive, "recursive", false, "Watch for changes in all subdirectories.")
+	flag.Var(&ignore, "ignore", "Pattern of paths to ignore, in .gitignore syntax, in addition to editor and version control files. May be repeated.")
+	flag.Var(&include, "include", "Pattern of files to watch, in .gitignore syntax. May be repeated. Defaults to all files.")
+	flag.BoolVar(&gitIgnore, "gitignore", true, "Ignore paths listed in .gitignore files.")
+	flag.BoolVar(&contentHash, "content-hash", false, "Ignore changes that leave the contents of files unchanged.")
+	flag.BoolVar(&poll, "poll", false, "Poll for changes instead of using file system notifications. Polling is used automatically if notifications are not available.")
+	flag.DurationVar(&pollInterval, "poll-interval", redgreen.DefaultPollInterval, "Time between scans for changes when polling.")
+	flag.BoolVar(&pollHash, "poll-hash", false, "Compare the contents of files when polling, for file systems with coarse modification times.")
 	flag.DurationVar(&timeout, "timeout", 5*time.Second, "Maximum time to wait for command to finish. Set to 0 to disable.")
+	flag.BoolVar(&restart, "restart", false, "Cancel a running command when files change and run it again.")
+	flag.BoolVar(&affected, "affected", false, "Run go test only for the packages affected by changed files.")
+	flag.IntVar(&fullEvery, "full-every", 10, "With -affected, run all tests after this many runs of affected packages. Set to 0 to disable.")
+	flag.StringVar(&sessionPath, "session", "", "File to record the history of runs in, in JSON Lines format.")
+	flag.BoolVar(&resume, "resume", false, "Restore the history of runs recorded in the -session file.")
+	flag.Var(&pilots, "pilots", "Comma-separated names of the participants of a Coding Dojo, to rotate as pilots.")
+	flag.DurationVar(&rotation, "rotation", redgreen.DefaultRotationInterval, "With -pilots, time each pilot leads before rotating.")
+	flag.BoolVar(&mute, "mute", false, "Start with sounds muted.")
+	flag.StringVar(&player, "player", "", "Player of sounds: aplay, paplay, pw-play or none. Defaults to the first one installed.")
+	flag.IntVar(&recoverAfter, "recover-after", redgreen.DefaultRecoverAfter, "Number of red runs in a row after which turning green plays the recovery sound. Set to 0 to disable.")
+	flag.IntVar(&outputLimit, "output-limit", redgreen.DefaultOutputLimit, "Maximum number of bytes of command output to keep. Set to -1 to disable.")
+}
+
+// stringList is a flag.Value that accumulates strings.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// commaList is a flag.Value that holds a comma-separated list of strings.
+type commaList []string
+
+func (l *commaList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *commaList) Set(s string) error {
+	*l = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		if err := report(os.Args[2:]); err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		return
+	}
+
 	flag.Parse()
 
+	if err := loadConfig(); err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	if player != "" {
+		sink, err := redgreen.ParsePlayer(player)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		sound.DefaultSink = sink
+	}
+
 	// Customize testCommand if passed as arguments.
 	if flag.NArg() > 0 {
 		testCommand = flag.Args()
@@ -37,6 +142,243 @@ func main() {
 	}
 }
 
+// report writes a report of a session recorded with -session.
+func report(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s report [flags] [session file]\n\nThe session file defaults to the one in the configuration file.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "", "Report format, markdown or html. Defaults to html if -o ends in .html, markdown otherwise.")
+	output := fs.String("o", "", "Write the report to this file instead of standard output.")
+	fs.Parse(args)
+	if err := loadConfig(); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		sessionPath = fs.Arg(0)
+	}
+	if sessionPath == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = "markdown"
+		if ext := filepath.Ext(*output); ext == ".html" || ext == ".htm" {
+			*format = "html"
+		}
+	}
+	sessions, err := redgreen.LoadSessions(sessionPath)
+	if err != nil {
+		return err
+	}
+	w := os.Stdout
+	if *output != "" {
+		if w, err = os.Create(*output); err != nil {
+			return err
+		}
+	}
+	if err := redgreen.NewReport(sessions...).WriteReport(w, *format); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// loadConfig reads the configuration file, if any, and applies its settings
+// unless they were overridden by command-line flags.
+func loadConfig() error {
+	path := configPath
+	if path == "" {
+		var err error
+		if path, err = redgreen.FindConfig("."); err != nil || path == "" {
+			return err
+		}
+	}
+	c, err := redgreen.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if c.Command != nil {
+		testCommand = c.Command
+	}
+	commands = c.Commands
+	if c.Timeout != nil && !set["timeout"] {
+		timeout = c.Timeout.Duration
+	}
+	if c.Debounce != nil && !set["debounce"] {
+		debounce = c.Debounce.Duration
+	}
+	if c.Watch != nil && !set["watch"] {
+		watchPaths = c.Watch
+	}
+	if c.Recursive != nil && !set["recursive"] {
+		recursive = *c.Recursive
+	}
+	if c.Ignore != nil && !set["ignore"] {
+		ignore = c.Ignore
+	}
+	if c.Include != nil && !set["include"] {
+		include = c.Include
+	}
+	if c.GitIgnore != nil && !set["gitignore"] {
+		gitIgnore = *c.GitIgnore
+	}
+	if c.ContentHash != nil && !set["content-hash"] {
+		contentHash = *c.ContentHash
+	}
+	if c.Poll != nil && !set["poll"] {
+		poll = *c.Poll
+	}
+	if c.PollInterval != nil && !set["poll-interval"] {
+		pollInterval = c.PollInterval.Duration
+	}
+	if c.PollHash != nil && !set["poll-hash"] {
+		pollHash = *c.PollHash
+	}
+	if c.Restart != nil && !set["restart"] {
+		restart = *c.Restart
+	}
+	if c.Session != "" && !set["session"] {
+		sessionPath = c.Session
+	}
+	if c.Resume != nil && !set["resume"] {
+		resume = *c.Resume
+	}
+	if c.Rotation.Pilots != nil && !set["pilots"] {
+		pilots = c.Rotation.Pilots
+	}
+	if c.Rotation.Interval != nil && !set["rotation"] {
+		rotation = c.Rotation.Interval.Duration
+	}
+	if c.Sounds.Mute != nil && !set["mute"] {
+		mute = *c.Sounds.Mute
+	}
+	if c.Sounds.Player != "" && !set["player"] {
+		player = c.Sounds.Player
+	}
+	if c.Sounds.RecoverAfter != nil && !set["recover-after"] {
+		recoverAfter = *c.Sounds.RecoverAfter
+	}
+	if c.OutputLimit != nil && !set["output-limit"] {
+		outputLimit = *c.OutputLimit
+	}
+	colors, _ = c.Palette()
+	hooks = c.Hooks
+	keymap, _ = c.Keymap()
+	sounds, _ = c.SoundMap()
+	return nil
+}
+
+// affectedSpec returns a copy of spec that runs go test only for the packages
+// affected by changes to files. It returns false if spec is not a go test
+// command or if the affected packages cannot be determined.
+func affectedSpec(spec redgreen.RunSpec, files []string) (redgreen.RunSpec, bool) {
+	if _, ok := redgreen.GoTestCommand(spec.Command, nil); !ok {
+		return spec, false
+	}
+	pkgs, err := redgreen.LoadGoPackages(".")
+	if err != nil {
+		if debug {
+			log.Println("affected packages:", err)
+		}
+		return spec, false
+	}
+	affected, ok := pkgs.Affected(files)
+	if !ok || len(affected) == 0 {
+		return spec, false
+	}
+	command, ok := redgreen.GoTestCommand(spec.Command, affected)
+	if !ok {
+		return spec, false
+	}
+	spec.Command = command
+	spec.Partial = true
+	return spec, true
+}
+
+// removeRunning removes the event of the command with the given name from
+// running, if any.
+func removeRunning(running []redgreen.RunEvent, name string) []redgreen.RunEvent {
+	for i, ev := range running {
+		if ev.Spec.Name == name {
+			return append(running[:i:i], running[i+1:]...)
+		}
+	}
+	return running
+}
+
+// An eventQueue accumulates the events of a command until it is ready to
+// handle them, so that commands do not wait for each other.
+type eventQueue struct {
+	mu     sync.Mutex
+	events []redgreen.Event
+	// ready holds a value when there may be events.
+	ready chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{ready: make(chan struct{}, 1)}
+}
+
+// push adds events to the queue.
+func (q *eventQueue) push(events []redgreen.Event) {
+	q.mu.Lock()
+	q.events = redgreen.Coalesce(q.events, events...)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes and returns all events in the queue.
+func (q *eventQueue) pop() []redgreen.Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	events := q.events
+	q.events = nil
+	return events
+}
+
+// alertRotation plays a sound and announces the new pilot.
+func alertRotation(pilot string) {
+	if err := sound.SuperNintendo.Play(); err != nil && debug {
+		log.Println("sound:", err)
+	}
+	if err := sound.Say(pilot + ", you are the pilot now."); err != nil && debug {
+		log.Println("sound:", err)
+	}
+}
+
+// playSound plays snd, logging errors in debug mode.
+func playSound(snd redgreen.Sound) {
+	if err := snd.Play(); err != nil && debug {
+		log.Println("sound:", err)
+	}
+}
+
+// runHook runs a hook command, if not empty. If status is not empty, it is
+// passed to the hook in the environment variable REDGREEN_STATUS.
+func runHook(command []string, status string) {
+	if len(command) == 0 {
+		return
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	if status != "" {
+		cmd.Env = append(os.Environ(), "REDGREEN_STATUS="+status)
+	}
+	out, err := cmd.CombinedOutput()
+	if debug {
+		log.Printf("hook: %s\n%s", strings.Join(cmd.Args, " "), out)
+		if err != nil {
+			log.Println("hook error:", err)
+		}
+	}
+}
+
 func do() error {
 	// Initialize and defer termination of termbox.
 	if !debug {
@@ -48,7 +390,33 @@ func do() error {
 		termbox.SetOutputMode(termbox.Output256)
 	}
 
-	// wg waits for all goroutines started by this function to return.
+	// Restore and record the history of runs. The log is closed after all
+	// goroutines that use it return.
+	var history []redgreen.RunResult
+	var sessionLog *redgreen.SessionLog
+	if sessionPath != "" {
+		var err error
+		if resume {
+			history, err = redgreen.LoadSession(sessionPath)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if sessionLog, err = redgreen.OpenSessionLog(sessionPath); err != nil {
+			return err
+		}
+		defer sessionLog.Close()
+		// Unless resuming, runs are part of a new session.
+		if !resume {
+			if err := sessionLog.Start(time.Now()); err != nil {
+				return err
+			}
+		}
+	}
+
+	// wg waits for all goroutines started by this function to return. In
+	// particular, it waits for the running test command, if any, to be
+	// terminated.
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
@@ -56,26 +424,171 @@ func do() error {
 	done := make(chan struct{})
 	defer close(done)
 
-	w, err := redgreen.Watch(done, ".", 200*time.Millisecond)
-	if err != nil {
-		return err
+	if len(watchPaths) == 0 {
+		watchPaths = []string{"."}
+	}
+	runSpecs := []redgreen.RunSpec{{Command: testCommand}}
+	if flag.NArg() == 0 && len(commands) > 0 {
+		runSpecs = nil
+		for _, c := range commands {
+			runSpecs = append(runSpecs, redgreen.RunSpec{
+				Name:     c.Name,
+				Command:  c.Command,
+				Patterns: c.Patterns,
+			})
+		}
 	}
+	run := make(chan redgreen.RunSpec, len(runSpecs))
+	events := redgreen.RunEvents(done, run)
 
-	runSpec := redgreen.RunSpec{Command: testCommand, Timeout: timeout}
-	run := make(chan redgreen.RunSpec, 1)
-	res := redgreen.Run(done, run)
+	s := redgreen.State{Results: history, Debug: debug, Colors: colors, Muted: mute, Help: keymap.Help()}
+	if len(pilots) > 0 {
+		s.Rotation = redgreen.Rotation{Participants: pilots, Interval: rotation, Start: time.Now()}
+	}
+	var mu sync.RWMutex // synchronizes access to s.
+	// send sends spec to be run. It returns false if done is closed first.
+	send := func(spec redgreen.RunSpec) bool {
+		select {
+		case run <- spec:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	// resumeCh holds one channel per command, sent to when watching is
+	// resumed after a pause.
+	resumeCh := make([]chan struct{}, len(runSpecs))
+	// rerunCh holds one channel per command, sent to when the user asks to
+	// run all commands. Requests made while one is pending are merged.
+	rerunCh := make([]chan struct{}, len(runSpecs))
 
-	// Trigger an initial run of the test command.
-	run <- runSpec
-	// Run tests every time a file is created/removed/modified.
+	// Watch once for all commands, including files matching the patterns
+	// of any of them, and route events to the commands whose patterns
+	// match.
+	watchSpec := redgreen.WatchSpec{
+		Paths:        watchPaths,
+		Delay:        debounce,
+		Recursive:    recursive,
+		Ignore:       append(append([]string(nil), redgreen.DefaultIgnore...), ignore...),
+		GitIgnore:    gitIgnore,
+		ContentHash:  contentHash,
+		PollInterval: pollInterval,
+		PollHash:     pollHash,
+	}
+	if poll {
+		watchSpec.Backend = redgreen.BackendPoll
+	}
+	// Writing to the session log must not trigger runs, which would write
+	// to it again.
+	if sessionPath != "" {
+		watchSpec.Ignore = append(watchSpec.Ignore, redgreen.IgnoreFile(watchPaths, sessionPath)...)
+	}
+	queues := make([]*eventQueue, len(runSpecs))
+	matchers := make([]*redgreen.Matcher, len(runSpecs))
+	// allFiles is set if some command is triggered by all files.
+	var allFiles bool
+	for i, runSpec := range runSpecs {
+		patterns := include
+		if len(runSpec.Patterns) > 0 {
+			patterns = runSpec.Patterns
+		}
+		allFiles = allFiles || len(patterns) == 0
+		watchSpec.Include = append(watchSpec.Include, patterns...)
+		queues[i] = newEventQueue()
+		matchers[i] = redgreen.NewMatcher(watchPaths, patterns)
+	}
+	if allFiles {
+		watchSpec.Include = nil
+	}
+	w, err := watchSpec.WatchEvents(done)
+	if err != nil {
+		return err
+	}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for range w {
-			run <- runSpec
+		for batch := range w {
+			for i, m := range matchers {
+				if events := m.Match(batch); len(events) > 0 {
+					queues[i].push(events)
+				}
+			}
 		}
 	}()
 
+	for i := range runSpecs {
+		runSpec := &runSpecs[i]
+		runSpec.Timeout = timeout
+		runSpec.OutputLimit = outputLimit
+		runSpec.Restart = restart
+
+		// Trigger an initial run of the test command.
+		runHook(hooks.BeforeRun, "")
+		send(*runSpec)
+		// Run tests every time a file is created/removed/modified.
+		wg.Add(1)
+		resumeCh[i] = make(chan struct{}, 1)
+		rerunCh[i] = make(chan struct{}, 1)
+		go func(runSpec redgreen.RunSpec, queue *eventQueue, resumeCh, rerunCh <-chan struct{}) {
+			defer wg.Done()
+			// partialRuns counts runs of affected packages since the
+			// last full run.
+			var partialRuns int
+			// held accumulates events while paused.
+			var held []redgreen.Event
+			for {
+				var events []redgreen.Event
+				// full is set when all tests should run, regardless
+				// of which files changed.
+				var full bool
+				select {
+				case <-queue.ready:
+					batch := queue.pop()
+					mu.RLock()
+					paused := s.Paused
+					mu.RUnlock()
+					if paused {
+						held = redgreen.Coalesce(held, batch...)
+						continue
+					}
+					// Include events held while paused, in case
+					// the resume signal was not received yet.
+					events, held = redgreen.Coalesce(held, batch...), nil
+				case <-resumeCh:
+					if len(held) == 0 {
+						continue
+					}
+					events, held = held, nil
+				case <-rerunCh:
+					full = true
+				case <-done:
+					return
+				}
+				spec := runSpec
+				if full {
+					partialRuns = 0
+				} else if affected {
+					var files []string
+					for _, ev := range events {
+						files = append(files, ev.Path)
+					}
+					var ok bool
+					spec, ok = affectedSpec(runSpec, files)
+					if ok && (fullEvery <= 0 || partialRuns < fullEvery) {
+						partialRuns++
+					} else {
+						spec, partialRuns = runSpec, 0
+					}
+				}
+				spec.Trigger = events
+				runHook(hooks.BeforeRun, "")
+				if !send(spec) {
+					return
+				}
+			}
+		}(*runSpec, queues[i], resumeCh[i], rerunCh[i])
+	}
+
 	state := make(chan redgreen.State)
 	wg.Add(1)
 	go func() {
@@ -83,22 +596,106 @@ func do() error {
 		redgreen.Render(done, state)
 	}()
 
-	s := redgreen.State{Debug: debug}
-	var mu sync.RWMutex // synchronizes access to s.
-
 	// Render initial state.
 	state <- s
-	// Render after every test command result.
+	// Render periodically to update timing information, and more often to
+	// animate the spinner while commands are running and alerts. Alert
+	// participants when pilots rotate.
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for r := range res {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		turn := s.Rotation.Turn(time.Now())
+		for tick := 1; ; tick++ {
+			var now time.Time
+			select {
+			case now = <-ticker.C:
+			case <-done:
+				return
+			}
+			mu.RLock()
+			if t := s.Rotation.Turn(now); t != turn {
+				turn = t
+				// The screen shows an alert even when muted.
+				if !s.Muted {
+					go alertRotation(s.Rotation.Pilot(now))
+				}
+			}
+			if !debug && (len(s.Running) > 0 || s.Rotation.Alerting(now) || tick%10 == 0) {
+				select {
+				case state <- s:
+				case <-done:
+				}
+			}
+			mu.RUnlock()
+		}
+	}()
+	// Render after every test command start and result.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ev := range events {
+			var r redgreen.RunResult
+			// confirm is set when a partial run passed, but failures
+			// of tests it did not run may remain.
+			var confirm bool
 			mu.Lock()
-			s.Results = append(s.Results, r)
+			s.Running = removeRunning(s.Running, ev.Spec.Name)
+			if ev.Result == nil {
+				s.Running = append(s.Running, ev)
+			} else {
+				r = *ev.Result
+				r.Pilot = s.Rotation.Pilot(r.Start)
+				s.AddResult(r)
+				if t, ok := s.Transition(recoverAfter); ok && !s.Muted {
+					go playSound(sounds[t])
+				}
+				if r.Partial && r.Status() == redgreen.StatusPass {
+					for _, latest := range s.Latest() {
+						if latest.Name == r.Name {
+							confirm = latest.Status() != redgreen.StatusPass
+						}
+					}
+				}
+			}
 			mu.Unlock()
+			if confirm {
+				// Run all tests of the command to find out whether
+				// the failures are gone.
+				for i := range runSpecs {
+					if runSpecs[i].Name != r.Name {
+						continue
+					}
+					select {
+					case rerunCh[i] <- struct{}{}:
+					default:
+					}
+				}
+			}
 			mu.RLock()
-			state <- s
+			select {
+			case state <- s:
+			case <-done:
+			}
 			mu.RUnlock()
+			if ev.Result == nil {
+				continue
+			}
+			if sessionLog != nil {
+				if err := sessionLog.Append(r); err != nil && debug {
+					log.Println("session:", err)
+				}
+			}
+			status := r.Status()
+			runHook(hooks.AfterRun, status.String())
+			switch status {
+			case redgreen.StatusPass:
+				runHook(hooks.OnPass, status.String())
+			case redgreen.StatusCancelled:
+			default:
+				runHook(hooks.OnFail, status.String())
+			}
 		}
 	}()
 
@@ -108,13 +705,66 @@ func do() error {
 		signal.Notify(ch, os.Interrupt)
 		<-ch
 	} else {
-		// Block until Esc is pressed.
+		// Handle key presses until quitting.
 		for {
 			e := termbox.PollEvent()
-			if e.Type == termbox.EventKey && e.Key == termbox.KeyEsc {
+			action, _ := keymap.Action(e)
+			if action == redgreen.ActionQuit {
 				break
 			}
-			if e.Type == termbox.EventResize {
+			mu.Lock()
+			switch action {
+			case redgreen.ActionRerun:
+				// Run all tests on demand.
+				for _, ch := range rerunCh {
+					select {
+					case ch <- struct{}{}:
+					default:
+					}
+				}
+			case redgreen.ActionPause:
+				s.Paused = !s.Paused
+				if !s.Paused {
+					// Run commands for changes made while paused.
+					for _, ch := range resumeCh {
+						select {
+						case ch <- struct{}{}:
+						default:
+						}
+					}
+				}
+			case redgreen.ActionOutput:
+				s.ShowOutput = !s.ShowOutput
+				s.ShowHistory = false
+				s.OutputScroll = 0
+			case redgreen.ActionHistory:
+				s.ShowHistory = !s.ShowHistory
+				s.ShowOutput = false
+			case redgreen.ActionScrollUp, redgreen.ActionScrollDown, redgreen.ActionPageUp, redgreen.ActionPageDown:
+				n := map[redgreen.Action]int{
+					redgreen.ActionScrollUp:   1,
+					redgreen.ActionScrollDown: -1,
+					redgreen.ActionPageUp:     10,
+					redgreen.ActionPageDown:   -10,
+				}[action]
+				if s.ShowHistory {
+					s.Select(n)
+				} else {
+					s.ScrollOutput(n)
+				}
+			case redgreen.ActionMute:
+				s.Muted = !s.Muted
+			case redgreen.ActionRotate:
+				s.Rotation.Next(time.Now())
+			case redgreen.ActionClear:
+				s.Results = nil
+				s.Selected = 0
+				s.OutputScroll = 0
+			case redgreen.ActionHelp:
+				s.ShowHelp = !s.ShowHelp
+			}
+			mu.Unlock()
+			if e.Type == termbox.EventKey || e.Type == termbox.EventResize {
 				mu.RLock()
 				state <- s
 				mu.RUnlock()
@@ -0,0 +1,196 @@
+package redgreen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// GoPackages holds the import graph of the packages in a Go module, to find the
+// packages affected by changes to files.
+type GoPackages struct {
+	// dirs maps absolute directories to package import paths.
+	dirs map[string]string
+	// importers maps import paths to the packages that import them.
+	importers map[string][]string
+	// testImporters maps import paths to the packages whose tests import
+	// them.
+	testImporters map[string][]string
+}
+
+// goPackage holds the fields of interest in the output of go list -json.
+type goPackage struct {
+	Dir          string
+	ImportPath   string
+	Imports      []string
+	TestImports  []string
+	XTestImports []string
+}
+
+// LoadGoPackages lists the packages matching patterns, "./..." if none, using
+// go list in dir.
+func LoadGoPackages(dir string, patterns ...string) (*GoPackages, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	cmd := exec.Command("go", append([]string{"list", "-e", "-json"}, patterns...)...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	p := &GoPackages{
+		dirs:          make(map[string]string),
+		importers:     make(map[string][]string),
+		testImporters: make(map[string][]string),
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var pkg goPackage
+		if err := dec.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("go list: %v", err)
+		}
+		p.dirs[pkg.Dir] = pkg.ImportPath
+		for _, imp := range pkg.Imports {
+			p.importers[imp] = append(p.importers[imp], pkg.ImportPath)
+		}
+		for _, imp := range append(pkg.TestImports, pkg.XTestImports...) {
+			p.testImporters[imp] = append(p.testImporters[imp], pkg.ImportPath)
+		}
+	}
+	return p, nil
+}
+
+// Package returns the import path of the package that contains file. Files in
+// existing subdirectories that are not packages themselves, such as testdata,
+// belong to the package in the closest parent directory. It returns false if
+// file is not part of any package, or if its directory does not exist, for
+// instance because a package was deleted.
+func (p *GoPackages) Package(file string) (string, bool) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return "", false
+	}
+	if fi, err := os.Stat(filepath.Dir(file)); err != nil || !fi.IsDir() {
+		return "", false
+	}
+	for dir := filepath.Dir(file); ; dir = filepath.Dir(dir) {
+		if pkg, ok := p.dirs[dir]; ok {
+			return pkg, true
+		}
+		if dir == filepath.Dir(dir) {
+			return "", false
+		}
+	}
+}
+
+// Affected returns the sorted import paths of the packages whose tests may be
+// affected by changes to files: the packages containing the files, the
+// packages that import them, directly or indirectly, and the packages whose
+// tests import any of those. It returns false if any of the files is not part
+// of a package, or is a go.mod or go.sum file, which may affect all packages.
+func (p *GoPackages) Affected(files []string) ([]string, bool) {
+	affected := make(map[string]bool)
+	var queue []string
+	for _, file := range files {
+		if base := filepath.Base(file); base == "go.mod" || base == "go.sum" {
+			return nil, false
+		}
+		pkg, ok := p.Package(file)
+		if !ok {
+			return nil, false
+		}
+		if !affected[pkg] {
+			affected[pkg] = true
+			queue = append(queue, pkg)
+		}
+	}
+	// Find all packages that import affected packages.
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, imp := range p.importers[pkg] {
+			if !affected[imp] {
+				affected[imp] = true
+				queue = append(queue, imp)
+			}
+		}
+	}
+	var pkgs []string
+	for pkg := range affected {
+		pkgs = append(pkgs, pkg)
+	}
+	// Tests of packages that import affected packages are also affected,
+	// but not the packages that import them.
+	for _, pkg := range pkgs {
+		for _, imp := range p.testImporters[pkg] {
+			affected[imp] = true
+		}
+	}
+	pkgs = pkgs[:0]
+	for pkg := range affected {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	return pkgs, true
+}
+
+// goTestValueFlags holds the flags of go test, and of the build and test
+// binary flags it accepts, that take a value as a separate argument.
+var goTestValueFlags = map[string]bool{
+	"C": true, "asmflags": true, "bench": true, "benchtime": true,
+	"blockprofile": true, "blockprofilerate": true, "buildmode": true,
+	"compiler": true, "count": true, "covermode": true, "coverpkg": true,
+	"coverprofile": true, "cpu": true, "cpuprofile": true, "exec": true,
+	"fuzz": true, "fuzzcachedir": true, "fuzzminimizetime": true,
+	"fuzztime": true, "gccgoflags": true, "gcflags": true,
+	"installsuffix": true, "ldflags": true, "list": true, "memprofile": true,
+	"memprofilerate": true, "mod": true, "modfile": true,
+	"mutexprofile": true, "mutexprofilefraction": true, "o": true,
+	"outputdir": true, "overlay": true, "p": true, "parallel": true,
+	"pgo": true, "pkgdir": true, "run": true, "shuffle": true, "skip": true,
+	"tags": true, "timeout": true, "toolexec": true, "trace": true,
+	"vet": true,
+}
+
+// GoTestCommand returns a copy of command, a go test command, with its package
+// arguments replaced by pkgs. Package arguments are the arguments that are not
+// flags or flag values, up to -args. It returns false if command is not a go
+// test command.
+func GoTestCommand(command []string, pkgs []string) ([]string, bool) {
+	if len(command) < 2 || filepath.Base(command[0]) != "go" || command[1] != "test" {
+		return nil, false
+	}
+	c := append([]string(nil), command[:2]...)
+	args := command[2:]
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "-args" || arg == "--args" {
+			break
+		}
+		args = args[1:]
+		if !strings.HasPrefix(arg, "-") {
+			// A package argument.
+			continue
+		}
+		c = append(c, arg)
+		name := strings.TrimLeft(arg, "-")
+		name = strings.TrimPrefix(name, "test.")
+		if !strings.Contains(name, "=") && goTestValueFlags[name] && len(args) > 0 {
+			c = append(c, args[0])
+			args = args[1:]
+		}
+	}
+	c = append(c, pkgs...)
+	return append(c, args...), true
+}
@@ -0,0 +1,326 @@
+package redgreen
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFileNames holds the names of configuration files, in order of
+// preference.
+var ConfigFileNames = []string{".redgreen.toml", ".redgreen.yaml", ".redgreen.yml"}
+
+// Config holds the settings read from a configuration file. Settings that are
+// not present in the file are nil.
+type Config struct {
+	Command      []string          `toml:"command" yaml:"command"`
+	Commands     []CommandConfig   `toml:"commands" yaml:"commands"`
+	Timeout      *Duration         `toml:"timeout" yaml:"timeout"`
+	Debounce     *Duration         `toml:"debounce" yaml:"debounce"`
+	Watch        []string          `toml:"watch" yaml:"watch"`
+	Recursive    *bool             `toml:"recursive" yaml:"recursive"`
+	Ignore       []string          `toml:"ignore" yaml:"ignore"`
+	Include      []string          `toml:"include" yaml:"include"`
+	GitIgnore    *bool             `toml:"gitignore" yaml:"gitignore"`
+	ContentHash  *bool             `toml:"content_hash" yaml:"content_hash"`
+	Poll         *bool             `toml:"poll" yaml:"poll"`
+	PollInterval *Duration         `toml:"poll_interval" yaml:"poll_interval"`
+	PollHash     *bool             `toml:"poll_hash" yaml:"poll_hash"`
+	Restart      *bool             `toml:"restart" yaml:"restart"`
+	OutputLimit  *int              `toml:"output_limit" yaml:"output_limit"`
+	Session      string            `toml:"session" yaml:"session"`
+	Resume       *bool             `toml:"resume" yaml:"resume"`
+	Colors       map[string]string `toml:"colors" yaml:"colors"`
+	Hooks        Hooks             `toml:"hooks" yaml:"hooks"`
+	Rotation     RotationConfig    `toml:"rotation" yaml:"rotation"`
+	Sounds       SoundsConfig      `toml:"sounds" yaml:"sounds"`
+	// Keys maps actions to the keys that trigger them, replacing their
+	// default keys.
+	Keys map[string][]string `toml:"keys" yaml:"keys"`
+}
+
+// CommandConfig holds the settings of one of multiple named test commands.
+type CommandConfig struct {
+	Name    string   `toml:"name" yaml:"name"`
+	Command []string `toml:"command" yaml:"command"`
+	// Patterns holds patterns of files whose changes trigger the command,
+	// see WatchSpec.Include.
+	Patterns []string `toml:"patterns" yaml:"patterns"`
+}
+
+// Hooks holds commands to be run around each execution of the test command.
+type Hooks struct {
+	// BeforeRun runs before the test command.
+	BeforeRun []string `toml:"before_run" yaml:"before_run"`
+	// AfterRun runs after the test command.
+	AfterRun []string `toml:"after_run" yaml:"after_run"`
+	// OnPass and OnFail run after the test command passes or fails,
+	// respectively.
+	OnPass []string `toml:"on_pass" yaml:"on_pass"`
+	OnFail []string `toml:"on_fail" yaml:"on_fail"`
+}
+
+// RotationConfig holds the settings of the rotation of pilots in a Coding Dojo.
+type RotationConfig struct {
+	Pilots   []string  `toml:"pilots" yaml:"pilots"`
+	Interval *Duration `toml:"interval" yaml:"interval"`
+}
+
+// SoundsConfig holds the settings of the sounds played on transitions of the
+// state. Sounds that are not set keep their default, see DefaultSounds.
+type SoundsConfig struct {
+	Mute *bool `toml:"mute" yaml:"mute"`
+	// Player is the name of the player of sounds, see ParsePlayer. By
+	// default, the first installed player is used.
+	Player string `toml:"player" yaml:"player"`
+	// RecoverAfter is the number of red runs in a row after which turning
+	// green is a recovery, see State.Transition.
+	RecoverAfter *int   `toml:"recover_after" yaml:"recover_after"`
+	Green        *Sound `toml:"green" yaml:"green"`
+	Red          *Sound `toml:"red" yaml:"red"`
+	Timeout      *Sound `toml:"timeout" yaml:"timeout"`
+	Recovery     *Sound `toml:"recovery" yaml:"recovery"`
+}
+
+// A Duration is a time.Duration written as a string such as "1m30s" in
+// configuration files.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// FindConfig looks for a configuration file in dir and its parent directories,
+// and returns the path of the first one found. It returns an empty string if
+// there is no configuration file.
+func FindConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range ConfigFileNames {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			} else if !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// LoadConfig reads the configuration file in path, in TOML or YAML format
+// according to its extension. Unknown settings are reported as errors.
+// Relative watch and session paths are made relative to the directory
+// containing the file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	switch ext := filepath.Ext(path); ext {
+	case ".toml":
+		md, err := toml.Decode(string(b), &c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, undecoded[0].String())
+		}
+	case ".yaml", ".yml":
+		if err := yaml.UnmarshalStrict(b, &c); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unknown configuration file format %q", path, ext)
+	}
+	names := make(map[string]bool)
+	for _, cmd := range c.Commands {
+		switch {
+		case cmd.Name == "":
+			return nil, fmt.Errorf("%s: command %q must have a name", path, cmd.Command)
+		case names[cmd.Name]:
+			return nil, fmt.Errorf("%s: duplicate command name %q", path, cmd.Name)
+		case len(cmd.Command) == 0:
+			return nil, fmt.Errorf("%s: command %q must not be empty", path, cmd.Name)
+		}
+		names[cmd.Name] = true
+	}
+	for i, p := range c.Watch {
+		if !filepath.IsAbs(p) {
+			c.Watch[i] = filepath.Join(filepath.Dir(path), p)
+		}
+	}
+	if c.Session != "" && !filepath.IsAbs(c.Session) {
+		c.Session = filepath.Join(filepath.Dir(path), c.Session)
+	}
+	for _, snd := range []*Sound{c.Sounds.Green, c.Sounds.Red, c.Sounds.Timeout, c.Sounds.Recovery} {
+		if snd != nil && snd.File != "" && !filepath.IsAbs(snd.File) {
+			snd.File = filepath.Join(filepath.Dir(path), snd.File)
+		}
+	}
+	if _, err := c.Palette(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if _, err := c.Keymap(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if _, err := c.SoundMap(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if c.Sounds.Player != "" {
+		if _, err := ParsePlayer(c.Sounds.Player); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return &c, nil
+}
+
+// statusNames maps the names used in configuration files to statuses.
+var statusNames = map[string]Status{
+	"pass":      StatusPass,
+	"fail":      StatusFail,
+	"build":     StatusBuildFail,
+	"timeout":   StatusTimeout,
+	"notfound":  StatusNotFound,
+	"signal":    StatusSignal,
+	"cancelled": StatusCancelled,
+}
+
+// Palette returns the colors configured for each status.
+func (c *Config) Palette() (map[Status]Color, error) {
+	if len(c.Colors) == 0 {
+		return nil, nil
+	}
+	palette := make(map[Status]Color)
+	for name, value := range c.Colors {
+		status, ok := statusNames[name]
+		if !ok {
+			var names []string
+			for name := range statusNames {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown status %q in colors, want one of %s", name, strings.Join(names, ", "))
+		}
+		color, err := ParseColor(value)
+		if err != nil {
+			return nil, err
+		}
+		palette[status] = color
+	}
+	return palette, nil
+}
+
+// Keymap returns the default keymap with the keys configured for each action.
+func (c *Config) Keymap() (Keymap, error) {
+	m := DefaultKeymap()
+	// Bind actions in a fixed order, so that keys configured for multiple
+	// actions are consistently bound to the last one.
+	for _, a := range Actions {
+		names, ok := c.Keys[string(a)]
+		if !ok {
+			continue
+		}
+		var keys []Key
+		for _, name := range names {
+			k, err := ParseKey(name)
+			if err != nil {
+				return nil, fmt.Errorf("%v for action %q", err, a)
+			}
+			keys = append(keys, k)
+		}
+		m.Bind(a, keys...)
+	}
+	for name := range c.Keys {
+		if _, ok := actionDescriptions[Action(name)]; !ok {
+			var names []string
+			for _, a := range Actions {
+				names = append(names, string(a))
+			}
+			return nil, fmt.Errorf("unknown action %q in keys, want one of %s", name, strings.Join(names, ", "))
+		}
+	}
+	return m, nil
+}
+
+// SoundMap returns the default sounds with the sounds configured for each
+// transition.
+func (c *Config) SoundMap() (map[Transition]Sound, error) {
+	m := DefaultSounds()
+	for _, t := range []struct {
+		transition Transition
+		sound      *Sound
+	}{
+		{TransitionGreen, c.Sounds.Green},
+		{TransitionRed, c.Sounds.Red},
+		{TransitionTimeout, c.Sounds.Timeout},
+		{TransitionRecovery, c.Sounds.Recovery},
+	} {
+		if t.sound == nil {
+			continue
+		}
+		if err := t.sound.validate(); err != nil {
+			return nil, fmt.Errorf("%v in sounds.%s", err, t.transition)
+		}
+		m[t.transition] = *t.sound
+	}
+	return m, nil
+}
+
+// colorNames maps color names to colors.
+var colorNames = map[string]Color{
+	"red":     ColorRed,
+	"green":   ColorGreen,
+	"yellow":  ColorYellow,
+	"blue":    ColorBlue,
+	"magenta": ColorMagenta,
+	"cyan":    ColorCyan,
+	"white":   ColorWhite,
+	"gray":    ColorGray,
+}
+
+// ParseColor parses a color name, such as "red", or a number between 0 and 255
+// representing a color in a 256-color terminal.
+func ParseColor(s string) (Color, error) {
+	if c, ok := colorNames[strings.ToLower(s)]; ok {
+		return c, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 255 {
+		return 0, errors.New("invalid color " + strconv.Quote(s))
+	}
+	// In 256-color mode, termbox represents color n as n+1, so that zero
+	// means the default color.
+	return Color(n + 1), nil
+}
@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -89,6 +91,74 @@ func TestRunClosedInput(t *testing.T) {
 	mustBeClosedTimeout(out, time.Second, t)
 }
 
+func TestRunRestart(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan redgreen.RunSpec)
+	out := redgreen.Run(done, in)
+
+	// Start a long running command that spawns a child process.
+	in <- redgreen.RunSpec{Command: []string{"sh", "-c", "sleep 10; true"}, Restart: true}
+	// A new spec should cancel the running command, killing all of its
+	// descendants.
+	in <- redgreen.RunSpec{Command: []string{"true"}}
+	for _, want := range []redgreen.Status{redgreen.StatusCancelled, redgreen.StatusPass} {
+		select {
+		case r := <-out:
+			if got := r.Status(); got != want {
+				t.Errorf("r.Status() = %v, want %v", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for result")
+		}
+	}
+}
+
+func TestRunRestartOtherName(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan redgreen.RunSpec)
+	out := redgreen.Run(done, in)
+
+	// A spec with a different name should not cancel the running command,
+	// and only the last spec with a given name should run afterwards.
+	in <- redgreen.RunSpec{Name: "a", Command: []string{"sleep", "0.2"}, Restart: true}
+	in <- redgreen.RunSpec{Name: "b", Command: []string{"false"}}
+	in <- redgreen.RunSpec{Name: "b", Command: []string{"true"}}
+	for _, want := range []string{"a pass", "b pass"} {
+		select {
+		case r := <-out:
+			if got := r.Name + " " + r.Status().String(); got != want {
+				t.Errorf("got result %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for result")
+		}
+	}
+}
+
+func TestRunEvents(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan redgreen.RunSpec)
+	out := redgreen.RunEvents(done, in)
+
+	in <- redgreen.RunSpec{Name: "a", Command: []string{"true"}}
+	for _, wantResult := range []bool{false, true} {
+		select {
+		case ev := <-out:
+			if ev.Spec.Name != "a" || (ev.Result != nil) != wantResult {
+				t.Errorf("got event %+v, want event for %q with result %v", ev, "a", wantResult)
+			}
+			if ev.Start.IsZero() {
+				t.Errorf("ev.Start is zero, want start time")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for event")
+		}
+	}
+}
+
 func mustBeClosedTimeout(ch <-chan redgreen.RunResult, timeout time.Duration, t *testing.T) {
 	select {
 	case _, isOpen := <-ch:
@@ -276,64 +346,1337 @@ func TestWatchModifyFile(t *testing.T) {
 	}
 }
 
-// mustBeClosedTimeoutESC is like mustBeClosedTimeout but takes a channel of
-// empty structs.
-func mustBeClosedTimeoutESC(ch <-chan struct{}, timeout time.Duration, t *testing.T) {
+func TestWatchRecursiveModifyFile(t *testing.T) {
+	path, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+	sub := filepath.Join(path, "a", "b")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatalf("create temp subdir: %v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	spec := redgreen.WatchSpec{Paths: []string{path}, Recursive: true}
+	out, err := spec.Watch(done)
+	if err != nil {
+		t.Fatalf("spec.Watch(done) = %v, want nil", err)
+	}
+
+	// Creating a file in a nested directory should trigger a watch event.
+	_, err = os.Create(filepath.Join(sub, "foo"))
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
 	select {
-	case _, isOpen := <-ch:
-		if isOpen {
-			t.Fatalf("channel is open, want closed")
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for watch event")
+	}
+}
+
+func TestWatchRecursiveFile(t *testing.T) {
+	path, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+	foo := filepath.Join(path, "foo")
+	if err := ioutil.WriteFile(foo, nil, 0644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	spec := redgreen.WatchSpec{Paths: []string{foo}, Recursive: true}
+	out, err := spec.WatchEvents(done)
+	if err != nil {
+		t.Fatalf("spec.WatchEvents(done) = %v, want nil", err)
+	}
+
+	// Writing to a watched file should trigger a watch event, even in
+	// recursive mode.
+	if err := ioutil.WriteFile(foo, []byte("test"), 0644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	select {
+	case events := <-out:
+		if len(events) != 1 || events[0].Path != foo || events[0].Op&redgreen.Write == 0 {
+			t.Errorf("got events %v, want a write to %q", events, foo)
 		}
-	case <-time.After(timeout):
-		t.Fatalf("receive from channel timed out")
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for watch event")
 	}
 }
 
-func TestStateColor(t *testing.T) {
-	var s redgreen.State
-	checkColor(s, redgreen.ColorYellow, t)
-	s.Results = append(s.Results, redgreen.RunResult{})
-	checkColor(s, redgreen.ColorGreen, t)
-	s.Results = append(s.Results, redgreen.RunResult{Error: errors.New("test")})
-	checkColor(s, redgreen.ColorRed, t)
+func TestWatchRecursiveNewDir(t *testing.T) {
+	path, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	done := make(chan struct{})
+	defer close(done)
+	spec := redgreen.WatchSpec{Paths: []string{path}, Delay: 50 * time.Millisecond, Recursive: true}
+	out, err := spec.Watch(done)
+	if err != nil {
+		t.Fatalf("spec.Watch(done) = %v, want nil", err)
+	}
+
+	// Creating a directory should trigger a watch event.
+	sub := filepath.Join(path, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("create temp subdir: %v", err)
+	}
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for watch event")
+	}
+
+	// The new directory should be watched as well.
+	_, err = os.Create(filepath.Join(sub, "foo"))
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for watch event")
+	}
+
+	// Removing the directory should trigger a watch event, and further
+	// events should continue to be delivered.
+	if err := os.RemoveAll(sub); err != nil {
+		t.Fatalf("remove temp subdir: %v", err)
+	}
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for watch event")
+	}
+	_, err = os.Create(filepath.Join(path, "bar"))
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for watch event")
+	}
 }
 
-func checkColor(s redgreen.State, want redgreen.Color, t *testing.T) {
-	if got := s.Color(); got != want {
-		t.Errorf("s.Color() = %v, want %v", got, want)
+func TestWatchIgnore(t *testing.T) {
+	path, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	done := make(chan struct{})
+	defer close(done)
+	spec := redgreen.WatchSpec{Paths: []string{path}, Ignore: []string{"*.swp"}}
+	out, err := spec.Watch(done)
+	if err != nil {
+		t.Fatalf("spec.Watch(done) = %v, want nil", err)
+	}
+
+	// Creating an ignored file should not trigger a watch event.
+	_, err = os.Create(filepath.Join(path, ".foo.swp"))
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	select {
+	case <-out:
+		t.Fatalf("got watch event for ignored file")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Creating any other file should trigger a watch event.
+	_, err = os.Create(filepath.Join(path, "foo"))
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for watch event")
 	}
 }
 
-func TestRenderDone(t *testing.T) {
+func TestWatchIgnoreFile(t *testing.T) {
+	path, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+	session := filepath.Join(path, ".redgreen", "session.jsonl")
+	l, err := redgreen.OpenSessionLog(session)
+	if err != nil {
+		t.Fatalf("OpenSessionLog(%q) = %v, want nil", session, err)
+	}
+	defer l.Close()
+
 	done := make(chan struct{})
-	in := make(chan redgreen.State)
-	ok := make(chan struct{}, 1)
-	go func() {
-		redgreen.Render(done, in)
-		ok <- struct{}{}
-	}()
-	in <- redgreen.State{}
-	close(done)
+	defer close(done)
+	spec := redgreen.WatchSpec{
+		Paths:     []string{path},
+		Recursive: true,
+		Ignore:    redgreen.IgnoreFile([]string{path}, session),
+	}
+	out, err := spec.Watch(done)
+	if err != nil {
+		t.Fatalf("spec.Watch(done) = %v, want nil", err)
+	}
+
+	// Appending to the session log should not trigger a watch event.
+	if err := l.Append(redgreen.RunResult{Start: time.Now()}); err != nil {
+		t.Fatalf("l.Append(r) = %v, want nil", err)
+	}
 	select {
-	case <-ok:
+	case <-out:
+		t.Fatalf("got watch event for ignored file")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Creating any other file should trigger a watch event, also in the
+	// same directory.
+	_, err = os.Create(filepath.Join(path, ".redgreen", "foo"))
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	select {
+	case <-out:
 	case <-time.After(time.Second):
-		t.Fatalf("timed out waiting goroutine to return")
+		t.Fatalf("timed out waiting for watch event")
 	}
 }
 
-func TestRenderClosedInput(t *testing.T) {
+func TestIgnoreFile(t *testing.T) {
+	tests := []struct {
+		paths []string
+		name  string
+		want  []string
+	}{
+		{[]string{"."}, "session.jsonl", []string{"/session.jsonl"}},
+		{[]string{"."}, "./.redgreen/session.jsonl", []string{"/.redgreen/session.jsonl"}},
+		{[]string{"a", "b"}, "a/x[1]*.log", []string{`/x\[1]\*.log`}},
+		{[]string{"a", "a/b"}, "a/b/c", []string{"/b/c", "/c"}},
+		{[]string{"a"}, "b/c", nil},
+		{[]string{"a"}, "a", nil},
+	}
+	for _, tt := range tests {
+		if got := redgreen.IgnoreFile(tt.paths, tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("IgnoreFile(%q, %q) = %q, want %q", tt.paths, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWatchPaths(t *testing.T) {
+	path, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+
 	done := make(chan struct{})
-	in := make(chan redgreen.State)
-	ok := make(chan struct{}, 1)
-	go func() {
-		redgreen.Render(done, in)
-		ok <- struct{}{}
-	}()
-	in <- redgreen.State{}
-	close(in)
+	defer close(done)
+	spec := redgreen.WatchSpec{Paths: []string{path}, Delay: 50 * time.Millisecond}
+	out, err := spec.WatchPaths(done)
+	if err != nil {
+		t.Fatalf("spec.WatchPaths(done) = %v, want nil", err)
+	}
+
+	// Multiple events within the delay should be sent together, without
+	// duplicates.
+	foo, bar := filepath.Join(path, "foo"), filepath.Join(path, "bar")
+	for _, name := range []string{foo, bar, foo} {
+		if err := ioutil.WriteFile(name, []byte("test"), 0644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
 	select {
-	case <-ok:
+	case paths := <-out:
+		if got, want := strings.Join(paths, " "), foo+" "+bar; got != want {
+			t.Errorf("got paths %q, want %q", got, want)
+		}
 	case <-time.After(time.Second):
-		t.Fatalf("timed out waiting goroutine to return")
+		t.Fatalf("timed out waiting for watch event")
+	}
+}
+
+func TestWatchEvents(t *testing.T) {
+	path, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+	bar := filepath.Join(path, "bar")
+	if err := ioutil.WriteFile(bar, nil, 0644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	spec := redgreen.WatchSpec{Paths: []string{path}, Delay: 50 * time.Millisecond}
+	out, err := spec.WatchEvents(done)
+	if err != nil {
+		t.Fatalf("spec.WatchEvents(done) = %v, want nil", err)
+	}
+
+	// Events on the same path should be coalesced.
+	foo := filepath.Join(path, "foo")
+	f, err := os.Create(foo)
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	f.WriteString("test")
+	f.Close()
+	if err := os.Remove(bar); err != nil {
+		t.Fatalf("remove temp file: %v", err)
+	}
+	select {
+	case events := <-out:
+		want := []redgreen.Event{
+			{Path: foo, Op: redgreen.Create | redgreen.Write},
+			{Path: bar, Op: redgreen.Remove},
+		}
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("got events %v, want %v", events, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for watch event")
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	path, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+	if err := os.Mkdir(filepath.Join(path, "web"), 0755); err != nil {
+		t.Fatalf("create dir: %v", err)
+	}
+	events := []redgreen.Event{
+		{Path: filepath.Join(path, "a.go"), Op: redgreen.Write},
+		{Path: filepath.Join(path, "web", "app.ts"), Op: redgreen.Write},
+		{Path: filepath.Join(path, "web"), Op: redgreen.Create},
+	}
+	tests := []struct {
+		include []string
+		want    []redgreen.Event
+	}{
+		{nil, events},
+		{[]string{"*.go"}, []redgreen.Event{events[0]}},
+		{[]string{"web/**/*.ts"}, []redgreen.Event{events[1]}},
+	}
+	for _, tt := range tests {
+		m := redgreen.NewMatcher([]string{path}, tt.include)
+		if got := m.Match(events); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NewMatcher(%q).Match(events) = %v, want %v", tt.include, got, tt.want)
+		}
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	events := []redgreen.Event{{Path: "a", Op: redgreen.Create}}
+	got := redgreen.Coalesce(events,
+		redgreen.Event{Path: "b", Op: redgreen.Write},
+		redgreen.Event{Path: "a", Op: redgreen.Write},
+		redgreen.Event{Path: "b", Op: redgreen.Remove},
+	)
+	want := []redgreen.Event{
+		{Path: "a", Op: redgreen.Create | redgreen.Write},
+		{Path: "b", Op: redgreen.Write | redgreen.Remove},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Coalesce(...) = %v, want %v", got, want)
+	}
+}
+
+func TestWatchPoll(t *testing.T) {
+	path, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	done := make(chan struct{})
+	defer close(done)
+	spec := redgreen.WatchSpec{
+		Paths:        []string{path},
+		Backend:      redgreen.BackendPoll,
+		PollInterval: 10 * time.Millisecond,
+	}
+	out, err := spec.WatchEvents(done)
+	if err != nil {
+		t.Fatalf("spec.WatchEvents(done) = %v, want nil", err)
+	}
+
+	// Creating a file should be noticed by the next scan.
+	foo := filepath.Join(path, "foo")
+	if err := ioutil.WriteFile(foo, []byte("test"), 0644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	select {
+	case events := <-out:
+		want := []redgreen.Event{{Path: foo, Op: redgreen.Create}}
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("got events %v, want %v", events, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for watch event")
+	}
+
+	// Watching a path that does not exist fails with any backend.
+	spec.Paths = []string{filepath.Join(path, "missing")}
+	if _, err := spec.WatchEvents(done); err == nil {
+		t.Errorf("spec.WatchEvents(done) = nil, want error for missing path")
+	}
+}
+
+func TestWatchPollSubdir(t *testing.T) {
+	path, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+	sub := filepath.Join(path, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("create dir: %v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	spec := redgreen.WatchSpec{
+		Paths:        []string{path},
+		Recursive:    true,
+		Ignore:       []string{"*.swp"},
+		Include:      []string{"*.go"},
+		Backend:      redgreen.BackendPoll,
+		PollInterval: 10 * time.Millisecond,
+	}
+	out, err := spec.WatchEvents(done)
+	if err != nil {
+		t.Fatalf("spec.WatchEvents(done) = %v, want nil", err)
+	}
+
+	// Writing an ignored file changes the modification time of its
+	// directory, which should not be reported either.
+	if err := ioutil.WriteFile(filepath.Join(sub, "x.swp"), []byte("test"), 0644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	select {
+	case events := <-out:
+		t.Errorf("got events %v, want none", events)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	foo := filepath.Join(sub, "foo.go")
+	if err := ioutil.WriteFile(foo, []byte("package foo"), 0644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	select {
+	case events := <-out:
+		want := []redgreen.Event{{Path: foo, Op: redgreen.Create}}
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("got events %v, want %v", events, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for watch event")
+	}
+}
+
+func TestWatchContentHash(t *testing.T) {
+	path, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+	foo := filepath.Join(path, "foo")
+	bar := filepath.Join(path, "bar")
+	if err := ioutil.WriteFile(bar, []byte("x"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	spec := redgreen.WatchSpec{Paths: []string{path}, Delay: 20 * time.Millisecond, ContentHash: true}
+	out, err := spec.WatchEvents(done)
+	if err != nil {
+		t.Fatalf("spec.WatchEvents(done) = %v, want nil", err)
+	}
+
+	for _, tt := range []struct {
+		desc   string
+		change func() error
+		want   bool
+	}{
+		{"existing, same contents", func() error { return ioutil.WriteFile(bar, []byte("x"), 0644) }, false},
+		{"existing, new contents", func() error { return ioutil.WriteFile(bar, []byte("y"), 0644) }, true},
+		{"create", func() error { return ioutil.WriteFile(foo, []byte("a"), 0644) }, true},
+		{"same contents", func() error { return ioutil.WriteFile(foo, []byte("a"), 0644) }, false},
+		{"new contents", func() error { return ioutil.WriteFile(foo, []byte("b"), 0644) }, true},
+		{"remove", func() error { return os.Remove(foo) }, true},
+		{"recreate", func() error { return ioutil.WriteFile(foo, []byte("b"), 0644) }, true},
+	} {
+		if err := tt.change(); err != nil {
+			t.Fatalf("%s: %v", tt.desc, err)
+		}
+		select {
+		case events := <-out:
+			if !tt.want {
+				t.Errorf("%s: got events %v, want none", tt.desc, events)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if tt.want {
+				t.Errorf("%s: timed out waiting for watch event", tt.desc)
+			}
+		}
+	}
+}
+
+// mustBeClosedTimeoutESC is like mustBeClosedTimeout but takes a channel of
+// empty structs.
+func mustBeClosedTimeoutESC(ch <-chan struct{}, timeout time.Duration, t *testing.T) {
+	select {
+	case _, isOpen := <-ch:
+		if isOpen {
+			t.Fatalf("channel is open, want closed")
+		}
+	case <-time.After(timeout):
+		t.Fatalf("receive from channel timed out")
+	}
+}
+
+func TestStateColor(t *testing.T) {
+	var s redgreen.State
+	checkColor(s, redgreen.ColorYellow, t)
+	s.Results = append(s.Results, redgreen.RunResult{})
+	checkColor(s, redgreen.ColorGreen, t)
+	s.Results = append(s.Results, redgreen.RunResult{Error: errors.New("test")})
+	checkColor(s, redgreen.ColorRed, t)
+	s.Results = append(s.Results, redgreen.RunResult{Error: &redgreen.BuildError{Err: errors.New("test")}})
+	checkColor(s, redgreen.ColorMagenta, t)
+	s.Results = append(s.Results, redgreen.RunResult{Error: &redgreen.TimeoutError{Err: errors.New("test")}})
+	checkColor(s, redgreen.ColorBlue, t)
+}
+
+func TestStateScrollOutput(t *testing.T) {
+	s := redgreen.State{Results: []redgreen.RunResult{{CombinedOutput: []byte("1\n2\n3\n")}}}
+	for _, tt := range []struct{ n, want int }{
+		{1, 1},
+		{5, 2},
+		{-1, 1},
+		{-5, 0},
+	} {
+		s.ScrollOutput(tt.n)
+		if s.OutputScroll != tt.want {
+			t.Errorf("after s.ScrollOutput(%d): s.OutputScroll = %d, want %d", tt.n, s.OutputScroll, tt.want)
+		}
+	}
+}
+
+func TestStateColorMultipleCommands(t *testing.T) {
+	var s redgreen.State
+	fail := errors.New("test")
+	for _, tt := range []struct {
+		r    redgreen.RunResult
+		want redgreen.Color
+	}{
+		{redgreen.RunResult{Name: "go"}, redgreen.ColorGreen},
+		{redgreen.RunResult{Name: "npm", Error: fail}, redgreen.ColorRed},
+		{redgreen.RunResult{Name: "go", Error: &redgreen.BuildError{Err: fail}}, redgreen.ColorMagenta},
+		{redgreen.RunResult{Name: "go", Error: redgreen.ErrCancelled}, redgreen.ColorMagenta},
+		{redgreen.RunResult{Name: "go"}, redgreen.ColorRed},
+		{redgreen.RunResult{Name: "npm"}, redgreen.ColorGreen},
+	} {
+		s.Results = append(s.Results, tt.r)
+		checkColor(s, tt.want, t)
+	}
+	var names []string
+	for _, r := range s.Latest() {
+		names = append(names, r.Name)
+	}
+	if got, want := strings.Join(names, ","), "go,npm"; got != want {
+		t.Errorf("names of s.Latest() = %q, want %q", got, want)
+	}
+}
+
+func TestStateColorPartial(t *testing.T) {
+	var s redgreen.State
+	fail := errors.New("test")
+	for _, tt := range []struct {
+		r    redgreen.RunResult
+		want redgreen.Color
+	}{
+		{redgreen.RunResult{Error: &redgreen.BuildError{Err: fail}}, redgreen.ColorMagenta},
+		// A partial run does not clear failures in tests it did not
+		// run, nor replace them with milder ones.
+		{redgreen.RunResult{Partial: true}, redgreen.ColorMagenta},
+		{redgreen.RunResult{Partial: true, Error: fail}, redgreen.ColorMagenta},
+		// A full run replaces any result.
+		{redgreen.RunResult{Error: fail}, redgreen.ColorRed},
+		{redgreen.RunResult{Partial: true, Error: &redgreen.TimeoutError{Err: fail}}, redgreen.ColorBlue},
+		{redgreen.RunResult{}, redgreen.ColorGreen},
+		// A partial run can turn the state red.
+		{redgreen.RunResult{Partial: true, Error: fail}, redgreen.ColorRed},
+	} {
+		s.Results = append(s.Results, tt.r)
+		checkColor(s, tt.want, t)
+	}
+}
+
+func TestStateSelect(t *testing.T) {
+	var s redgreen.State
+	if _, ok := s.SelectedResult(); ok {
+		t.Errorf("s.SelectedResult() = _, true, want false without results")
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		s.AddResult(redgreen.RunResult{Name: name})
+	}
+	for _, tt := range []struct {
+		n    int
+		want string
+	}{
+		{0, "c"},
+		{1, "b"},
+		{5, "a"},
+		{-1, "b"},
+		{-5, "c"},
+	} {
+		s.Select(tt.n)
+		if r, _ := s.SelectedResult(); r.Name != tt.want {
+			t.Errorf("after s.Select(%d), selected %q, want %q", tt.n, r.Name, tt.want)
+		}
+	}
+	// New results should not change the selection, unless following the
+	// last result.
+	s.AddResult(redgreen.RunResult{Name: "d"})
+	if r, _ := s.SelectedResult(); r.Name != "d" {
+		t.Errorf("selected %q, want %q", r.Name, "d")
+	}
+	s.Select(1)
+	s.AddResult(redgreen.RunResult{Name: "e"})
+	if r, _ := s.SelectedResult(); r.Name != "c" {
+		t.Errorf("selected %q, want %q", r.Name, "c")
+	}
+}
+
+func TestRunResultHistoryLine(t *testing.T) {
+	r := redgreen.RunResult{
+		Name:     "go",
+		Start:    time.Date(2020, 1, 1, 15, 4, 5, 0, time.Local),
+		Duration: 1234 * time.Millisecond,
+		Error:    errors.New("fail"),
+		Trigger:  []redgreen.Event{{Path: "a/foo.go"}, {Path: "bar.go"}},
+	}
+	want := "15:04:05    1.2s ✘ fail     go ← foo.go, bar.go"
+	if got := r.HistoryLine(); got != want {
+		t.Errorf("r.HistoryLine() = %q, want %q", got, want)
+	}
+	if got, want := r.End(), r.Start.Add(r.Duration); !got.Equal(want) {
+		t.Errorf("r.End() = %v, want %v", got, want)
+	}
+}
+
+func TestStateStreak(t *testing.T) {
+	fail := redgreen.RunResult{Error: errors.New("fail")}
+	cancelled := redgreen.RunResult{Error: redgreen.ErrCancelled}
+	pass := redgreen.RunResult{}
+	tests := []struct {
+		results  []redgreen.RunResult
+		wantPass bool
+		wantN    int
+	}{
+		{nil, false, 0},
+		{[]redgreen.RunResult{cancelled}, false, 0},
+		{[]redgreen.RunResult{pass, fail, fail, cancelled, fail}, false, 3},
+		{[]redgreen.RunResult{fail, pass, cancelled}, true, 1},
+	}
+	for _, tt := range tests {
+		s := redgreen.State{Results: tt.results}
+		if pass, n := s.Streak(); pass != tt.wantPass || n != tt.wantN {
+			t.Errorf("s.Streak() = %v, %d, want %v, %d", pass, n, tt.wantPass, tt.wantN)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := redgreen.Rotation{Participants: []string{"ana", "bo", "cy"}, Interval: 5 * time.Minute, Start: start}
+	tests := []struct {
+		after          time.Duration
+		pilot, copilot string
+		left           time.Duration
+		turn           int
+		alerting       bool
+	}{
+		{0, "ana", "bo", 5 * time.Minute, 0, false},
+		{4 * time.Minute, "ana", "bo", time.Minute, 0, false},
+		{5*time.Minute + 2*time.Second, "bo", "cy", 4*time.Minute + 58*time.Second, 1, true},
+		{6 * time.Minute, "bo", "cy", 4 * time.Minute, 1, false},
+		{14 * time.Minute, "cy", "ana", time.Minute, 2, false},
+		{15 * time.Minute, "ana", "bo", 5 * time.Minute, 3, true},
+	}
+	for _, tt := range tests {
+		now := start.Add(tt.after)
+		if got := r.Pilot(now); got != tt.pilot {
+			t.Errorf("after %v: r.Pilot() = %q, want %q", tt.after, got, tt.pilot)
+		}
+		if got := r.Copilot(now); got != tt.copilot {
+			t.Errorf("after %v: r.Copilot() = %q, want %q", tt.after, got, tt.copilot)
+		}
+		if got := r.Left(now); got != tt.left {
+			t.Errorf("after %v: r.Left() = %v, want %v", tt.after, got, tt.left)
+		}
+		if got := r.Turn(now); got != tt.turn {
+			t.Errorf("after %v: r.Turn() = %d, want %d", tt.after, got, tt.turn)
+		}
+		if got := r.Alerting(now); got != tt.alerting {
+			t.Errorf("after %v: r.Alerting() = %v, want %v", tt.after, got, tt.alerting)
+		}
+	}
+
+	// Rotating ahead of schedule gives the copilot a full interval.
+	now := start.Add(2 * time.Minute)
+	r.Next(now)
+	if got, want := r.Pilot(now), "bo"; got != want {
+		t.Errorf("after Next: r.Pilot() = %q, want %q", got, want)
+	}
+	if got, want := r.Left(now), 5*time.Minute; got != want {
+		t.Errorf("after Next: r.Left() = %v, want %v", got, want)
+	}
+	if !r.Alerting(now) {
+		t.Error("after Next: r.Alerting() = false, want true")
+	}
+
+	var none redgreen.Rotation
+	if none.Enabled() || none.Pilot(now) != "" || none.Alerting(now) {
+		t.Errorf("zero Rotation is enabled: %+v", none)
+	}
+}
+
+func TestStateTransition(t *testing.T) {
+	fail := redgreen.RunResult{Error: errors.New("fail")}
+	cancelled := redgreen.RunResult{Error: redgreen.ErrCancelled}
+	timeout := redgreen.RunResult{Error: &redgreen.TimeoutError{}}
+	pass := redgreen.RunResult{}
+	tests := []struct {
+		results []redgreen.RunResult
+		want    redgreen.Transition
+		wantOK  bool
+	}{
+		{nil, "", false},
+		{[]redgreen.RunResult{pass}, "", false},
+		{[]redgreen.RunResult{pass, pass}, "", false},
+		{[]redgreen.RunResult{pass, fail}, redgreen.TransitionRed, true},
+		{[]redgreen.RunResult{fail, fail}, "", false},
+		{[]redgreen.RunResult{fail, pass}, redgreen.TransitionGreen, true},
+		{[]redgreen.RunResult{fail, cancelled}, "", false},
+		{[]redgreen.RunResult{pass, timeout}, redgreen.TransitionTimeout, true},
+		{[]redgreen.RunResult{fail, timeout}, redgreen.TransitionTimeout, true},
+		{[]redgreen.RunResult{timeout, timeout}, "", false},
+		{[]redgreen.RunResult{fail, fail, pass}, redgreen.TransitionGreen, true},
+		{[]redgreen.RunResult{fail, fail, cancelled, timeout, pass}, redgreen.TransitionRecovery, true},
+	}
+	for _, tt := range tests {
+		s := redgreen.State{Results: tt.results}
+		if got, ok := s.Transition(3); got != tt.want || ok != tt.wantOK {
+			t.Errorf("s.Transition(3) with %d results = %q, %v, want %q, %v", len(tt.results), got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRunResultTriggerSummary(t *testing.T) {
+	tests := []struct {
+		paths []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"a/foo_test.go"}, "triggered by foo_test.go"},
+		{[]string{"a", "b", "c", "d", "e"}, "triggered by a, b, c, and 2 more"},
+	}
+	for _, tt := range tests {
+		var r redgreen.RunResult
+		for _, path := range tt.paths {
+			r.Trigger = append(r.Trigger, redgreen.Event{Path: path, Op: redgreen.Write})
+		}
+		if got := r.TriggerSummary(); got != tt.want {
+			t.Errorf("r.TriggerSummary() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func checkColor(s redgreen.State, want redgreen.Color, t *testing.T) {
+	if got := s.Color(); got != want {
+		t.Errorf("s.Color() = %v, want %v", got, want)
+	}
+}
+
+func TestRenderDone(t *testing.T) {
+	done := make(chan struct{})
+	in := make(chan redgreen.State)
+	ok := make(chan struct{}, 1)
+	go func() {
+		redgreen.Render(done, in)
+		ok <- struct{}{}
+	}()
+	in <- redgreen.State{}
+	close(done)
+	select {
+	case <-ok:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting goroutine to return")
+	}
+}
+
+func TestRenderClosedInput(t *testing.T) {
+	done := make(chan struct{})
+	in := make(chan redgreen.State)
+	ok := make(chan struct{}, 1)
+	go func() {
+		redgreen.Render(done, in)
+		ok <- struct{}{}
+	}()
+	in <- redgreen.State{}
+	close(in)
+	select {
+	case <-ok:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting goroutine to return")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		".redgreen.toml": `
+command = ["go", "test", "./..."]
+timeout = "1m"
+watch = ["src"]
+recursive = true
+
+[colors]
+pass = "34"
+fail = "magenta"
+
+[hooks]
+on_fail = ["notify-send", "red"]
+
+[[commands]]
+name = "web"
+command = ["npm", "test"]
+patterns = ["*.ts"]
+`,
+		".redgreen.yaml": `
+command: [go, test, ./...]
+timeout: 1m
+watch: [src]
+recursive: true
+colors:
+  pass: "34"
+  fail: magenta
+hooks:
+  on_fail: [notify-send, red]
+commands:
+  - name: web
+    command: [npm, test]
+    patterns: ["*.ts"]
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+		c, err := redgreen.LoadConfig(path)
+		if err != nil {
+			t.Errorf("LoadConfig(%q) = %v, want nil", name, err)
+			continue
+		}
+		if got, want := strings.Join(c.Command, " "), "go test ./..."; got != want {
+			t.Errorf("%s: c.Command = %q, want %q", name, got, want)
+		}
+		if c.Timeout == nil || c.Timeout.Duration != time.Minute {
+			t.Errorf("%s: c.Timeout = %v, want %v", name, c.Timeout, time.Minute)
+		}
+		if c.Debounce != nil {
+			t.Errorf("%s: c.Debounce = %v, want nil", name, c.Debounce)
+		}
+		if want := []string{filepath.Join(dir, "src")}; !reflect.DeepEqual(c.Watch, want) {
+			t.Errorf("%s: c.Watch = %q, want %q", name, c.Watch, want)
+		}
+		if c.Recursive == nil || !*c.Recursive {
+			t.Errorf("%s: c.Recursive = %v, want true", name, c.Recursive)
+		}
+		wantCommands := []redgreen.CommandConfig{{Name: "web", Command: []string{"npm", "test"}, Patterns: []string{"*.ts"}}}
+		if !reflect.DeepEqual(c.Commands, wantCommands) {
+			t.Errorf("%s: c.Commands = %+v, want %+v", name, c.Commands, wantCommands)
+		}
+		if want := []string{"notify-send", "red"}; !reflect.DeepEqual(c.Hooks.OnFail, want) {
+			t.Errorf("%s: c.Hooks.OnFail = %q, want %q", name, c.Hooks.OnFail, want)
+		}
+		palette, err := c.Palette()
+		want := map[redgreen.Status]redgreen.Color{
+			redgreen.StatusPass: redgreen.Color(35),
+			redgreen.StatusFail: redgreen.ColorMagenta,
+		}
+		if err != nil || !reflect.DeepEqual(palette, want) {
+			t.Errorf("%s: c.Palette() = %v, %v, want %v, nil", name, palette, err, want)
+		}
+		os.Remove(path)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"unknown.toml":    "unknown = true\n",
+		"unknown.yaml":    "unknown: true\n",
+		"duration.toml":   "timeout = \"forever\"\n",
+		"color.yaml":      "colors: {pass: pink}\n",
+		"status.toml":     "[colors]\npink = \"red\"\n",
+		"redgreen.ini":    "",
+		"commands.yaml":   "commands: [{name: a, command: [true]}, {name: a, command: [false]}]\n",
+		"action.toml":     "[keys]\njump = [\"j\"]\n",
+		"key.yaml":        "keys: {quit: [ctrl-x]}\n",
+		"sound.toml":      "[sounds.green]\nsound = \"trumpet\"\n",
+		"sounds.yaml":     "sounds: {red: {say: red, file: red.wav}}\n",
+		"melody.toml":     "[sounds.red]\nmelody = \"H4:4\"\n",
+		"waveform.yaml":   "sounds: {red: {melody: C4, waveform: noise}}\n",
+		"tempo.toml":      "[sounds.red]\nsay = \"red\"\ntempo = 90\n",
+		"does-not-exist.": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if content != "" {
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("write temp file: %v", err)
+			}
+		}
+		if _, err := redgreen.LoadConfig(path); err == nil {
+			t.Errorf("LoadConfig(%q) = nil, want not nil", name)
+		}
+	}
+}
+
+func TestConfigSoundMap(t *testing.T) {
+	c := redgreen.Config{Sounds: redgreen.SoundsConfig{
+		Red:   &redgreen.Sound{File: "red.wav"},
+		Green: &redgreen.Sound{Melody: "C4:8 E4:8 G4:4", Waveform: "triangle", Tempo: 90, Volume: 0.3},
+	}}
+	m, err := c.SoundMap()
+	if err != nil {
+		t.Fatalf("c.SoundMap() error = %v", err)
+	}
+	if got, want := m[redgreen.TransitionRed], (redgreen.Sound{File: "red.wav"}); got != want {
+		t.Errorf("m[TransitionRed] = %+v, want %+v", got, want)
+	}
+	if got, want := m[redgreen.TransitionGreen], *c.Sounds.Green; got != want {
+		t.Errorf("m[TransitionGreen] = %+v, want %+v", got, want)
+	}
+	if got, want := m[redgreen.TransitionTimeout], redgreen.DefaultSounds()[redgreen.TransitionTimeout]; got != want {
+		t.Errorf("m[TransitionTimeout] = %+v, want default %+v", got, want)
+	}
+}
+
+func TestSoundPlayNotWAV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "red.mp3")
+	if err := ioutil.WriteFile(name, []byte("ID3\x03\x00"), 0644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	// Files other than WAV should not be played as raw samples.
+	snd := redgreen.Sound{File: name}
+	if err := snd.Play(); err == nil || !strings.Contains(err.Error(), "not a WAV file") {
+		t.Errorf("snd.Play() = %v, want not a WAV file error", err)
+	}
+}
+
+func TestConfigKeymap(t *testing.T) {
+	c := redgreen.Config{Keys: map[string][]string{
+		"rerun": {"space"},
+		"quit":  {"x", "r"},
+	}}
+	m, err := c.Keymap()
+	if err != nil {
+		t.Fatalf("c.Keymap() = %v, want nil", err)
+	}
+	for _, tt := range []struct {
+		key  string
+		want redgreen.Action
+	}{
+		{"space", redgreen.ActionRerun},
+		{"enter", ""},
+		{"x", redgreen.ActionQuit},
+		{"r", redgreen.ActionQuit},
+		{"esc", ""},
+		{"p", redgreen.ActionPause},
+		{"?", redgreen.ActionHelp},
+	} {
+		k, err := redgreen.ParseKey(tt.key)
+		if err != nil {
+			t.Errorf("ParseKey(%q) = %v, want nil", tt.key, err)
+			continue
+		}
+		if got := m[k]; got != tt.want {
+			t.Errorf("action for %q = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+	help := m.Help()
+	if got, want := help[0], "space  run all commands now"; got != want {
+		t.Errorf("m.Help()[0] = %q, want %q", got, want)
+	}
+	if got, want := help[len(help)-1], "r, x   quit"; got != want {
+		t.Errorf("last line of m.Help() = %q, want %q", got, want)
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	sub := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatalf("create temp subdir: %v", err)
+	}
+	want := filepath.Join(dir, "a", ".redgreen.yml")
+	if err := ioutil.WriteFile(want, nil, 0644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	if got, err := redgreen.FindConfig(sub); got != want || err != nil {
+		t.Errorf("FindConfig(%q) = %q, %v, want %q, nil", sub, got, err, want)
+	}
+}
+
+func TestSessionLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "logs", "session.jsonl")
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	results := []redgreen.RunResult{
+		{
+			Command:        []string{"go", "test"},
+			Start:          start,
+			Duration:       time.Second,
+			CombinedOutput: []byte("ok"),
+		},
+		{
+			Name:     "web",
+			Partial:  true,
+			Trigger:  []redgreen.Event{{Path: "foo.ts", Op: redgreen.Create | redgreen.Write}},
+			Start:    start.Add(time.Minute),
+			Duration: 5 * time.Second,
+			Error:    &redgreen.TimeoutError{Timeout: 5 * time.Second, Err: errors.New("signal: terminated")},
+		},
+		{
+			Start: start.Add(2 * time.Minute),
+			Error: &redgreen.BuildError{Err: errors.New("exit status 2")},
+			Tests: []redgreen.TestResult{{Package: "foo", Test: "TestFoo", Action: "fail", Output: "--- FAIL"}},
+		},
+	}
+	// Logs should be appended to when opened again.
+	for _, batch := range [][]redgreen.RunResult{results[:1], results[1:]} {
+		l, err := redgreen.OpenSessionLog(path)
+		if err != nil {
+			t.Fatalf("OpenSessionLog(%q) = %v, want nil", path, err)
+		}
+		for _, r := range batch {
+			if err := l.Append(r); err != nil {
+				t.Errorf("l.Append(r) = %v, want nil", err)
+			}
+		}
+		if err := l.Close(); err != nil {
+			t.Errorf("l.Close() = %v, want nil", err)
+		}
+	}
+	got, err := redgreen.LoadSession(path)
+	if err != nil {
+		t.Fatalf("LoadSession(%q) = %v, want nil", path, err)
+	}
+	if len(got) != len(results) {
+		t.Fatalf("LoadSession(%q) returned %d results, want %d", path, len(got), len(results))
+	}
+	for i, r := range got {
+		want := results[i]
+		if r.Status() != want.Status() || r.Name != want.Name || !r.Start.Equal(want.Start) || r.Duration != want.Duration {
+			t.Errorf("result %d = %+v, want %+v", i, r, want)
+		}
+		if !reflect.DeepEqual(r.Trigger, want.Trigger) || !reflect.DeepEqual(r.Command, want.Command) || r.Partial != want.Partial {
+			t.Errorf("result %d = %+v, want %+v", i, r, want)
+		}
+		if want.Error != nil && r.Error.Error() != want.Error.Error() {
+			t.Errorf("result %d: error %q, want %q", i, r.Error, want.Error)
+		}
+		if r.CombinedOutput != nil {
+			t.Errorf("result %d: output %q, want none", i, r.CombinedOutput)
+		}
+	}
+	if got, want := got[2].TestSummary(), "1 of 1 tests failing: TestFoo"; got != want {
+		t.Errorf("got[2].TestSummary() = %q, want %q", got, want)
+	}
+
+	// A new session starts with a record, and is the only one restored.
+	l, err := redgreen.OpenSessionLog(path)
+	if err != nil {
+		t.Fatalf("OpenSessionLog(%q) = %v, want nil", path, err)
+	}
+	next := redgreen.RunResult{Command: []string{"go", "test"}, Start: start.Add(24 * time.Hour)}
+	if err := l.Start(next.Start); err != nil {
+		t.Errorf("l.Start(t) = %v, want nil", err)
+	}
+	if err := l.Append(next); err != nil {
+		t.Errorf("l.Append(r) = %v, want nil", err)
+	}
+	l.Close()
+	if got, err := redgreen.LoadSession(path); err != nil || len(got) != 1 || !got[0].Start.Equal(next.Start) {
+		t.Errorf("LoadSession(%q) = %+v, %v, want only the last session", path, got, err)
+	}
+	sessions, err := redgreen.LoadSessions(path)
+	if err != nil {
+		t.Fatalf("LoadSessions(%q) = %v, want nil", path, err)
+	}
+	if len(sessions) != 2 || len(sessions[0]) != len(results) || len(sessions[1]) != 1 {
+		t.Errorf("LoadSessions(%q) = %d sessions, want 2 with %d and 1 results", path, len(sessions), len(results))
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	var results []redgreen.RunResult
+	// Each run takes a second and starts a minute after the previous one.
+	for i, tt := range []struct {
+		pilot string
+		err   error
+	}{
+		{"ana", nil},
+		{"ana", errors.New("fail")},
+		{"ana", redgreen.ErrCancelled},
+		{"ana", &redgreen.BuildError{}},
+		{"bo", nil},
+		{"bo", errors.New("fail")},
+		{"bo", nil},
+	} {
+		results = append(results, redgreen.RunResult{
+			Command:  []string{"go", "test"},
+			Start:    start.Add(time.Duration(i) * time.Minute),
+			Duration: time.Second,
+			Pilot:    tt.pilot,
+			Error:    tt.err,
+		})
+	}
+	r := redgreen.NewReport(results)
+	if got, want := r.Green, 2*time.Minute; got != want {
+		t.Errorf("r.Green = %v, want %v", got, want)
+	}
+	if got, want := r.Red, 4*time.Minute; got != want {
+		t.Errorf("r.Red = %v, want %v", got, want)
+	}
+	if got, want := r.Cycles, 2; got != want {
+		t.Errorf("r.Cycles = %v, want %v", got, want)
+	}
+	if got, want := r.LongestRed.Duration(), 3*time.Minute; got != want {
+		t.Errorf("r.LongestRed.Duration() = %v, want %v", got, want)
+	}
+	if got, want := r.LongestRed.Runs, 3; got != want {
+		t.Errorf("r.LongestRed.Runs = %v, want %v", got, want)
+	}
+	wantPilots := []redgreen.PilotReport{
+		{Pilot: "ana", Runs: 4, Red: 3 * time.Minute, Green: time.Minute},
+		{Pilot: "bo", Runs: 3, Red: time.Minute, Green: time.Minute, Cycles: 2},
+	}
+	if !reflect.DeepEqual(r.Pilots, wantPilots) {
+		t.Errorf("r.Pilots = %+v, want %+v", r.Pilots, wantPilots)
+	}
+
+	for _, format := range []string{"markdown", "html"} {
+		var b strings.Builder
+		if err := r.WriteReport(&b, format); err != nil {
+			t.Errorf("r.WriteReport(&b, %q) = %v, want nil", format, err)
+		}
+		for _, want := range []string{"67%", "3m0s, 3 runs", "ana", "go test"} {
+			if !strings.Contains(b.String(), want) {
+				t.Errorf("%s report does not contain %q:\n%s", format, want, b.String())
+			}
+		}
+	}
+	if err := r.WriteReport(ioutil.Discard, "pdf"); err == nil {
+		t.Errorf("r.WriteReport(ioutil.Discard, %q) = nil, want error", "pdf")
+	}
+}
+
+func TestNewReportSessions(t *testing.T) {
+	day1 := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	run := func(start time.Time, err error) redgreen.RunResult {
+		return redgreen.RunResult{Command: []string{"sh", "-c", "a | b"}, Start: start, Duration: time.Second, Error: err}
+	}
+	fail := errors.New("fail")
+	sessions := [][]redgreen.RunResult{
+		{run(day1, fail), run(day1.Add(time.Minute), nil)},
+		{run(day2, nil), run(day2.Add(2*time.Minute), fail)},
+	}
+	for _, r := range []redgreen.Report{
+		redgreen.NewReport(sessions...),
+		// Long gaps split sessions even without boundaries.
+		redgreen.NewReport(append(append([]redgreen.RunResult(nil), sessions[0]...), sessions[1]...)),
+	} {
+		if got, want := r.Sessions, 2; got != want {
+			t.Errorf("r.Sessions = %v, want %v", got, want)
+		}
+		if got, want := r.Duration, 3*time.Minute+2*time.Second; got != want {
+			t.Errorf("r.Duration = %v, want %v", got, want)
+		}
+		if got, want := r.Green, 2*time.Minute; got != want {
+			t.Errorf("r.Green = %v, want %v", got, want)
+		}
+		if got, want := r.Red, time.Minute; got != want {
+			t.Errorf("r.Red = %v, want %v", got, want)
+		}
+		if got, want := r.Cycles, 1; got != want {
+			t.Errorf("r.Cycles = %v, want %v", got, want)
+		}
+		if got, want := len(r.Segments), 4; got != want {
+			t.Errorf("len(r.Segments) = %v, want %v", got, want)
+		}
+		if got, want := r.LongestRed.Runs, 1; got != want {
+			t.Errorf("r.LongestRed.Runs = %v, want %v", got, want)
+		}
+		var b strings.Builder
+		if err := r.WriteMarkdown(&b); err != nil {
+			t.Fatalf("r.WriteMarkdown(&b) = %v, want nil", err)
+		}
+		if want := `sh -c a \| b`; !strings.Contains(b.String(), want) {
+			t.Errorf("markdown report does not contain %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestGoPackagesAffected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"go.mod":           "module example.com/m\n",
+		"m.go":             "package m\n",
+		"docs/x.txt":       "",
+		"a/a.go":           "package a\n",
+		"a/testdata/x.txt": "",
+		"b/b.go":           "package b\nimport _ \"example.com/m/a\"\n",
+		"c/c.go":           "package c\n",
+		"c/c_test.go":      "package c\nimport _ \"example.com/m/b\"\n",
+		"d/d.go":           "package d\nimport _ \"example.com/m/c\"\n",
+		"e/e.go":           "package e\n",
+		"old/old.go":       "package old\n",
+		"user/user.go":     "package user\nimport _ \"example.com/m/old\"\n",
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("create temp subdir: %v", err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	// Files in deleted packages cannot be attributed to a package.
+	if err := os.RemoveAll(filepath.Join(dir, "old")); err != nil {
+		t.Fatalf("remove temp subdir: %v", err)
+	}
+	pkgs, err := redgreen.LoadGoPackages(dir)
+	if err != nil {
+		t.Fatalf("LoadGoPackages(%q) = %v, want nil", dir, err)
+	}
+	tests := []struct {
+		files  []string
+		want   string
+		wantOK bool
+	}{
+		{files: []string{"a/a.go"}, want: "a b c", wantOK: true},
+		{files: []string{"a/testdata/x.txt"}, want: "a b c", wantOK: true},
+		{files: []string{"c/c.go"}, want: "c d", wantOK: true},
+		{files: []string{"e/e.go", "e/e_test.go"}, want: "e", wantOK: true},
+		{files: []string{"e/e.go", "go.mod"}, wantOK: false},
+		{files: []string{"docs/x.txt"}, want: "example.com/m", wantOK: true},
+		{files: []string{"f/f.go"}, wantOK: false},
+		{files: []string{"old/old.go"}, wantOK: false},
+	}
+	for _, tt := range tests {
+		var paths []string
+		for _, f := range tt.files {
+			paths = append(paths, filepath.Join(dir, filepath.FromSlash(f)))
+		}
+		affected, ok := pkgs.Affected(paths)
+		got := strings.Replace(strings.Join(affected, " "), "example.com/m/", "", -1)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("pkgs.Affected(%q) = %q, %v, want %q, %v", tt.files, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestGoTestCommand(t *testing.T) {
+	pkgs := []string{"example.com/m/a", "example.com/m/b"}
+	tests := []struct {
+		command []string
+		want    string
+		wantOK  bool
+	}{
+		{
+			command: []string{"go", "test"},
+			want:    "go test example.com/m/a example.com/m/b",
+			wantOK:  true,
+		},
+		{
+			command: []string{"go", "test", "-json", "./...", "-run", "TestFoo"},
+			want:    "go test -json -run TestFoo example.com/m/a example.com/m/b",
+			wantOK:  true,
+		},
+		{
+			command: []string{"go", "test", "-run", ".", "./..."},
+			want:    "go test -run . example.com/m/a example.com/m/b",
+			wantOK:  true,
+		},
+		{
+			command: []string{"go", "test", "-o", "./bin/x.test", "-count=1", "./pkg", "example.com/m/c"},
+			want:    "go test -o ./bin/x.test -count=1 example.com/m/a example.com/m/b",
+			wantOK:  true,
+		},
+		{
+			command: []string{"go", "test", "-v", "./...", "-args", "-update", "."},
+			want:    "go test -v example.com/m/a example.com/m/b -args -update .",
+			wantOK:  true,
+		},
+		{command: []string{"go", "vet", "./..."}},
+		{command: []string{"make", "test"}},
+	}
+	for _, tt := range tests {
+		command, ok := redgreen.GoTestCommand(tt.command, pkgs)
+		if got := strings.Join(command, " "); got != tt.want || ok != tt.wantOK {
+			t.Errorf("GoTestCommand(%q, pkgs) = %q, %v, want %q, %v", tt.command, got, ok, tt.want, tt.wantOK)
+		}
 	}
 }
@@ -0,0 +1,300 @@
+package redgreen
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultIgnore holds patterns of paths that are typically not interesting to
+// watch: version control metadata and temporary files created by editors.
+var DefaultIgnore = []string{
+	".git/",
+	".hg/",
+	".svn/",
+	"*.swp",
+	"*.swo",
+	"*.swx",
+	"*~",
+	"4913",
+	".#*",
+	"#*#",
+}
+
+// IgnoreFile returns ignore patterns, see WatchSpec.Ignore, that match the file
+// name relative to each of paths that contains it. It is used to stop files
+// written by redgreen itself, such as the session log, from triggering runs.
+func IgnoreFile(paths []string, name string) []string {
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return nil
+	}
+	var patterns []string
+	for _, root := range paths {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		patterns = append(patterns, "/"+patternEscaper.Replace(filepath.ToSlash(rel)))
+	}
+	return patterns
+}
+
+// patternEscaper escapes the characters that have special meaning in
+// patterns, so that the result matches literally.
+var patternEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+
+// A pattern is a single glob pattern using the syntax of .gitignore files.
+type pattern struct {
+	// segments holds the slash-separated parts of the pattern, each
+	// matched with path.Match, except for "**" which matches zero or more
+	// path segments.
+	segments []string
+	// anchored patterns are matched against the full path relative to the
+	// directory the pattern is defined in, while other patterns are
+	// matched against the last path element only.
+	anchored bool
+	// dirOnly patterns only match directories.
+	dirOnly bool
+	// negate patterns re-include paths excluded by previous patterns.
+	negate bool
+}
+
+// parsePattern parses a line in the format of a .gitignore file. It returns
+// false if the line does not contain a pattern.
+func parsePattern(line string) (pattern, bool) {
+	var p pattern
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return p, false
+	}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return p, false
+	}
+	p.segments = strings.Split(line, "/")
+	return p, true
+}
+
+// match reports whether p matches the path made of the given elements. The
+// elements are relative to the directory p is defined in.
+func (p pattern) match(elems []string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if !p.anchored {
+		ok, _ := path.Match(p.segments[0], elems[len(elems)-1])
+		return ok
+	}
+	return matchSegments(p.segments, elems)
+}
+
+// matchSegments reports whether the pattern segments pat match all of elems.
+func matchSegments(pat, elems []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchSegments(pat[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], elems[0]); !ok {
+			return false
+		}
+		pat, elems = pat[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+// parsePatterns parses each element of lines as a pattern, skipping blank
+// lines and comments.
+func parsePatterns(lines []string) []pattern {
+	var patterns []pattern
+	for _, line := range lines {
+		if p, ok := parsePattern(line); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// A filter decides which paths under a set of root directories are relevant
+// for watching. It is not safe for concurrent use.
+type filter struct {
+	roots     []string
+	ignore    []pattern
+	include   []pattern
+	gitIgnore bool
+	// gitIgnoreCache maps directories to the patterns in their .gitignore
+	// file.
+	gitIgnoreCache map[string][]pattern
+}
+
+// newFilter returns a filter for the paths, ignore and include patterns in
+// spec.
+func newFilter(spec WatchSpec) *filter {
+	f := &filter{
+		ignore:         parsePatterns(spec.Ignore),
+		include:        parsePatterns(spec.Include),
+		gitIgnore:      spec.GitIgnore,
+		gitIgnoreCache: make(map[string][]pattern),
+	}
+	for _, root := range spec.Paths {
+		f.roots = append(f.roots, filepath.Clean(root))
+	}
+	return f
+}
+
+// split returns the root directory containing name and the elements of name
+// relative to that root. It returns a nil slice if name is a root or is not
+// under any root.
+func (f *filter) split(name string) (root string, elems []string) {
+	name = filepath.Clean(name)
+	for _, root := range f.roots {
+		rel, err := filepath.Rel(root, name)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return root, strings.Split(filepath.ToSlash(rel), "/")
+	}
+	return "", nil
+}
+
+// ignored reports whether name should be ignored, either because it matches an
+// ignore pattern or because it is under an ignored directory.
+func (f *filter) ignored(name string, isDir bool) bool {
+	root, elems := f.split(name)
+	for i := 1; i <= len(elems); i++ {
+		if f.excluded(root, elems[:i], isDir || i < len(elems)) {
+			return true
+		}
+	}
+	return false
+}
+
+// relevant reports whether an event on name should be reported: name must not
+// be ignored and, if it is a file and include patterns were given, it must
+// match at least one of them.
+func (f *filter) relevant(name string, isDir bool) bool {
+	if f.ignored(name, isDir) {
+		return false
+	}
+	if isDir || len(f.include) == 0 {
+		return true
+	}
+	_, elems := f.split(name)
+	if elems == nil {
+		return true
+	}
+	for _, p := range f.include {
+		if p.match(elems, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded applies all patterns to elems, relative to root, and reports
+// whether the last matching pattern excludes it. Patterns from .gitignore files
+// apply first, from the outermost to the innermost directory, and the ignore
+// patterns of the filter apply last, so that they take precedence.
+func (f *filter) excluded(root string, elems []string, isDir bool) bool {
+	var excluded bool
+	apply := func(patterns []pattern, elems []string) {
+		for _, p := range patterns {
+			if p.match(elems, isDir) {
+				excluded = !p.negate
+			}
+		}
+	}
+	if f.gitIgnore {
+		dir := root
+		for i := range elems {
+			apply(f.gitIgnorePatterns(dir), elems[i:])
+			dir = filepath.Join(dir, elems[i])
+		}
+	}
+	apply(f.ignore, elems)
+	return excluded
+}
+
+// gitIgnorePatterns returns the patterns in the .gitignore file in dir, if
+// any.
+func (f *filter) gitIgnorePatterns(dir string) []pattern {
+	if patterns, ok := f.gitIgnoreCache[dir]; ok {
+		return patterns
+	}
+	var lines []string
+	if file, err := os.Open(filepath.Join(dir, ".gitignore")); err == nil {
+		s := bufio.NewScanner(file)
+		for s.Scan() {
+			lines = append(lines, s.Text())
+		}
+		file.Close()
+	}
+	patterns := parsePatterns(lines)
+	f.gitIgnoreCache[dir] = patterns
+	return patterns
+}
+
+// invalidate discards cached information about name, so that changes to
+// .gitignore files are taken into account.
+func (f *filter) invalidate(name string) {
+	if filepath.Base(name) == ".gitignore" {
+		delete(f.gitIgnoreCache, filepath.Dir(filepath.Clean(name)))
+	}
+}
+
+// A Matcher selects events on files that match include patterns, see
+// WatchSpec.Include, so that a single watcher can serve multiple commands.
+type Matcher struct {
+	f *filter
+}
+
+// NewMatcher returns a Matcher for the include patterns, relative to the
+// watched paths. A Matcher without patterns matches all events.
+func NewMatcher(paths, include []string) *Matcher {
+	return &Matcher{f: newFilter(WatchSpec{Paths: paths, Include: include})}
+}
+
+// Match returns the events in events on files that match the patterns of m.
+// Unless m has no patterns, events on directories never match.
+func (m *Matcher) Match(events []Event) []Event {
+	if len(m.f.include) == 0 {
+		return events
+	}
+	var matched []Event
+	for _, ev := range events {
+		if fi, err := os.Stat(ev.Path); err == nil && fi.IsDir() {
+			continue
+		}
+		if m.f.relevant(ev.Path, false) {
+			matched = append(matched, ev)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,199 @@
+package redgreen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A TestEvent is an event in the output of go test -json, as documented in
+// https://golang.org/cmd/test2json.
+type TestEvent struct {
+	Time        time.Time
+	Action      string
+	Package     string
+	ImportPath  string
+	Test        string
+	Elapsed     float64 // seconds
+	Output      string
+	FailedBuild string
+}
+
+// A TestResult holds the outcome of a single test, or of a whole package when
+// Test is empty.
+type TestResult struct {
+	Package string `json:"package"`
+	Test    string `json:"test,omitempty"`
+	// Action is one of "pass", "fail" or "skip".
+	Action  string        `json:"action"`
+	Elapsed time.Duration `json:"elapsed"`
+	Output  string        `json:"output,omitempty"`
+}
+
+// testActions holds the actions of test events.
+var testActions = map[string]bool{
+	"start":        true,
+	"run":          true,
+	"pause":        true,
+	"cont":         true,
+	"pass":         true,
+	"bench":        true,
+	"fail":         true,
+	"output":       true,
+	"skip":         true,
+	"build-output": true,
+	"build-fail":   true,
+}
+
+// isTestEvent reports whether ev looks like an event of go test -json, as
+// opposed to any JSON object with an action, such as a log line of some
+// other command.
+func isTestEvent(ev TestEvent) bool {
+	return testActions[ev.Action] && (ev.Package != "" || ev.ImportPath != "" || !ev.Time.IsZero())
+}
+
+// buildFailedRE matches the line go test prints for packages that could not be
+// built.
+var buildFailedRE = regexp.MustCompile(`^FAIL\s.*\[(build|setup) failed\]`)
+
+// testOutputLimit is the maximum number of bytes of output kept for each
+// TestResult.
+const testOutputLimit = 64 << 10
+
+// A testParser is an io.Writer that parses the output of go test -json. Lines
+// that are not test events are passed through to the underlying writer, and
+// test events have their output passed through, so that the underlying writer
+// sees the same as if go test had run without -json.
+type testParser struct {
+	w io.Writer
+	// line holds an incomplete line.
+	line []byte
+	// running maps package and test names to results that are not yet
+	// complete.
+	running  map[[2]string]*testResult
+	packages []TestResult
+	tests    []TestResult
+	// buildFailed is set if any package failed to build.
+	buildFailed bool
+}
+
+// testResult is a TestResult being built.
+type testResult struct {
+	TestResult
+	output *tailBuffer
+}
+
+// newTestParser returns a testParser that passes plain text output through to
+// w.
+func newTestParser(w io.Writer) *testParser {
+	return &testParser{w: w, running: make(map[[2]string]*testResult)}
+}
+
+// Write parses complete lines in b, keeping any incomplete line for the next
+// call. It returns an error only if writing to the underlying writer fails.
+func (p *testParser) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			p.line = append(p.line, b...)
+			break
+		}
+		p.line = append(p.line, b[:i+1]...)
+		b = b[i+1:]
+		if err := p.parseLine(); err != nil {
+			return n - len(b), err
+		}
+	}
+	return n, nil
+}
+
+// Flush parses any incomplete line left from previous calls to Write.
+func (p *testParser) Flush() error {
+	if len(p.line) == 0 {
+		return nil
+	}
+	return p.parseLine()
+}
+
+// parseLine parses p.line and resets it.
+func (p *testParser) parseLine() error {
+	defer func() { p.line = p.line[:0] }()
+	var ev TestEvent
+	if !bytes.HasPrefix(p.line, []byte("{")) || json.Unmarshal(p.line, &ev) != nil || !isTestEvent(ev) {
+		if buildFailedRE.Match(p.line) {
+			p.buildFailed = true
+		}
+		_, err := p.w.Write(p.line)
+		return err
+	}
+	p.handle(ev)
+	_, err := io.WriteString(p.w, ev.Output)
+	return err
+}
+
+// handle updates the results being built according to ev.
+func (p *testParser) handle(ev TestEvent) {
+	if ev.Action == "build-fail" || ev.FailedBuild != "" || buildFailedRE.MatchString(ev.Output) {
+		p.buildFailed = true
+	}
+	if ev.Package == "" {
+		// Events such as build-output are not related to tests.
+		return
+	}
+	key := [2]string{ev.Package, ev.Test}
+	r := p.running[key]
+	if r == nil {
+		r = &testResult{
+			TestResult: TestResult{Package: ev.Package, Test: ev.Test},
+			output:     newTailBuffer(testOutputLimit),
+		}
+		p.running[key] = r
+	}
+	switch ev.Action {
+	case "output":
+		r.output.Write([]byte(ev.Output))
+	case "pass", "fail", "skip":
+		r.Action = ev.Action
+		r.Elapsed = time.Duration(ev.Elapsed * float64(time.Second))
+		r.Output = string(r.output.Bytes())
+		delete(p.running, key)
+		if ev.Test == "" {
+			p.packages = append(p.packages, r.TestResult)
+		} else {
+			p.tests = append(p.tests, r.TestResult)
+		}
+	}
+}
+
+// TestSummary returns a short description of the outcome of the tests in r, or
+// an empty string if there are no test results. Subtests are not counted.
+func (r RunResult) TestSummary() string {
+	var total int
+	var failed []string
+	for _, t := range r.Tests {
+		if strings.Contains(t.Test, "/") || t.Action == "skip" {
+			continue
+		}
+		total++
+		if t.Action == "fail" {
+			failed = append(failed, t.Test)
+		}
+	}
+	switch {
+	case total == 0:
+		return ""
+	case len(failed) == 0:
+		return fmt.Sprintf("%d of %d tests passing", total, total)
+	}
+	n := len(failed)
+	const maxNames = 5
+	if n > maxNames {
+		failed = append(failed[:maxNames], "…")
+	}
+	return fmt.Sprintf("%d of %d tests failing: %s", n, total, strings.Join(failed, ", "))
+}
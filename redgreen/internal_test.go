@@ -1,9 +1,15 @@
 package redgreen
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -14,6 +20,7 @@ func Test_run(t *testing.T) {
 		command []string
 		timeout time.Duration
 		check   checkFunc
+		status  Status
 	}{
 		{
 			command: []string{},
@@ -25,30 +32,92 @@ func Test_run(t *testing.T) {
 				}
 				return nil
 			},
+			status: StatusFail,
 		},
 		{
 			command: []string{"invalid command"},
 			check:   isExecError,
+			status:  StatusNotFound,
 		},
 		{
 			command: []string{"true"},
 			check:   isNil,
+			status:  StatusPass,
 		},
 		{
 			command: []string{"false"},
 			check:   isSignal(-1),
+			status:  StatusFail,
 		},
 		{
 			command: []string{"sleep", "2"},
 			timeout: 1 * time.Nanosecond,
-			check:   isSignal(syscall.SIGKILL),
+			check:   isSignal(syscall.SIGTERM),
+			status:  StatusTimeout,
+		},
+		{
+			command: []string{"sh", "-c", "kill -TERM $$"},
+			check:   isSignal(syscall.SIGTERM),
+			status:  StatusSignal,
+		},
+		{
+			command: []string{"sh", "-c", "echo 'FAIL\tp [build failed]'; exit 1"},
+			check:   isSignal(-1),
+			status:  StatusBuildFail,
+		},
+		{
+			command: []string{"sh", "-c", `echo '{"Action":"fail","Package":"p","FailedBuild":"p"}'; exit 1`},
+			check:   isSignal(-1),
+			status:  StatusBuildFail,
 		},
 	}
 	for _, tt := range tests {
-		err := run(tt.command, tt.timeout, false)
-		if checkErr := tt.check(err); checkErr != nil {
+		r := run(RunSpec{Command: tt.command, Timeout: tt.timeout}, nil, false)
+		if checkErr := tt.check(r.Error); checkErr != nil {
 			t.Errorf("run(%v, %v): %v", tt.command, tt.timeout, checkErr)
 		}
+		if got := r.Status(); got != tt.status {
+			t.Errorf("run(%v, %v).Status() = %v, want %v", tt.command, tt.timeout, got, tt.status)
+		}
+	}
+}
+
+func Test_runOutput(t *testing.T) {
+	tests := []struct {
+		limit         int
+		wantOutput    string
+		wantTruncated bool
+	}{
+		{limit: 0, wantOutput: "out\nerr\n"},
+		{limit: -1, wantOutput: "out\nerr\n"},
+		{limit: 8, wantOutput: "out\nerr\n"},
+		{limit: 4, wantOutput: "err\n", wantTruncated: true},
+	}
+	for _, tt := range tests {
+		spec := RunSpec{Command: []string{"sh", "-c", "echo out; echo err >&2"}, OutputLimit: tt.limit}
+		r := run(spec, nil, false)
+		if r.Error != nil {
+			t.Fatalf("run(%v): %v", spec, r.Error)
+		}
+		if got := string(r.CombinedOutput); got != tt.wantOutput {
+			t.Errorf("run(%v).CombinedOutput = %q, want %q", spec, got, tt.wantOutput)
+		}
+		if r.Truncated != tt.wantTruncated {
+			t.Errorf("run(%v).Truncated = %v, want %v", spec, r.Truncated, tt.wantTruncated)
+		}
+	}
+}
+
+func Test_tailBuffer(t *testing.T) {
+	b := newTailBuffer(5)
+	for _, s := range []string{"ab", "cd", "efghijklmn", "op"} {
+		b.Write([]byte(s))
+	}
+	if got, want := string(b.Bytes()), "lmnop"; got != want {
+		t.Errorf("b.Bytes() = %q, want %q", got, want)
+	}
+	if !b.Truncated() {
+		t.Errorf("b.Truncated() = false, want true")
 	}
 }
 
@@ -63,15 +132,16 @@ func isNil(err error) error {
 	return nil
 }
 func isExecError(err error) error {
-	if _, ok := err.(*exec.Error); !ok {
+	var execErr *exec.Error
+	if !errors.As(err, &execErr) {
 		return fmt.Errorf("got %T (%[1]v), want *exec.Error", err)
 	}
 	return nil
 }
 func isSignal(want syscall.Signal) checkFunc {
 	return func(err error) error {
-		exitErr, ok := err.(*exec.ExitError)
-		if !ok {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
 			return fmt.Errorf("got %T (%[1]v), want *exec.ExitError", err)
 		}
 		status, ok := exitErr.Sys().(syscall.WaitStatus)
@@ -85,3 +155,184 @@ func isSignal(want syscall.Signal) checkFunc {
 		return nil
 	}
 }
+
+func Test_filter(t *testing.T) {
+	root, err := ioutil.TempDir("", "redgreen")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	files := map[string]string{
+		".gitignore":     "/bin/\n*.out\n!keep.out\n",
+		"sub/.gitignore": "generated.go\n",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("create temp subdir: %v", err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	f := newFilter(WatchSpec{
+		Paths:     []string{root},
+		Ignore:    DefaultIgnore,
+		Include:   []string{"*.go", "*.out", "docs/**/*.md"},
+		GitIgnore: true,
+	})
+	tests := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{name: "main.go", want: true},
+		{name: "README.md", want: false},
+		{name: "docs/README.md", want: true},
+		{name: "docs/a/b/README.md", want: true},
+		{name: "sub", isDir: true, want: true},
+		{name: "sub/main.go", want: true},
+		{name: "sub/generated.go", want: false},
+		{name: "generated.go", want: true},
+		{name: ".main.go.swp", want: false},
+		{name: "main.go~", want: false},
+		{name: "4913", want: false},
+		{name: ".git", isDir: true, want: false},
+		{name: ".git/index", want: false},
+		{name: "bin", isDir: true, want: false},
+		{name: "bin/main.go", want: false},
+		{name: "sub/bin", isDir: true, want: true},
+		{name: "c.out", want: false},
+		{name: "sub/c.out", want: false},
+		{name: "keep.out", want: true},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(root, filepath.FromSlash(tt.name))
+		if got := f.relevant(name, tt.isDir); got != tt.want {
+			t.Errorf("f.relevant(%q, %v) = %v, want %v", tt.name, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func Test_testParser(t *testing.T) {
+	input := `{"Action":"run","Package":"p","Test":"TestA"}
+{"Action":"output","Package":"p","Test":"TestA","Output":"=== RUN   TestA\n"}
+{"Action":"output","Package":"p","Test":"TestA","Output":"    a_test.go:1: boom\n"}
+{"Action":"fail","Package":"p","Test":"TestA","Elapsed":0.5}
+{"Action":"run","Package":"p","Test":"TestB"}
+{"Action":"pass","Package":"p","Test":"TestB","Elapsed":0}
+{"Action":"skip","Package":"p","Test":"TestC","Elapsed":0}
+not a test event
+{"action":"deploy","target":"prod"}
+{"Action":"output","Output":"no package\n"}
+{"Action":"output","Package":"p","Output":"FAIL\n"}
+{"Action":"fail","Package":"p","Elapsed":1}`
+	var b bytes.Buffer
+	p := newTestParser(&b)
+	// Write in small chunks to exercise handling of incomplete lines.
+	for in := input; len(in) > 0; {
+		n := 7
+		if n > len(in) {
+			n = len(in)
+		}
+		if _, err := p.Write([]byte(in[:n])); err != nil {
+			t.Fatalf("p.Write: %v", err)
+		}
+		in = in[n:]
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatalf("p.Flush: %v", err)
+	}
+
+	wantOutput := "=== RUN   TestA\n    a_test.go:1: boom\nnot a test event\n" +
+		`{"action":"deploy","target":"prod"}` + "\n" +
+		`{"Action":"output","Output":"no package\n"}` + "\nFAIL\n"
+	if got := b.String(); got != wantOutput {
+		t.Errorf("output = %q, want %q", got, wantOutput)
+	}
+	wantTests := []TestResult{
+		{Package: "p", Test: "TestA", Action: "fail", Elapsed: 500 * time.Millisecond, Output: "=== RUN   TestA\n    a_test.go:1: boom\n"},
+		{Package: "p", Test: "TestB", Action: "pass"},
+		{Package: "p", Test: "TestC", Action: "skip"},
+	}
+	if !reflect.DeepEqual(p.tests, wantTests) {
+		t.Errorf("tests = %+v, want %+v", p.tests, wantTests)
+	}
+	wantPackages := []TestResult{
+		{Package: "p", Action: "fail", Elapsed: time.Second, Output: "FAIL\n"},
+	}
+	if !reflect.DeepEqual(p.packages, wantPackages) {
+		t.Errorf("packages = %+v, want %+v", p.packages, wantPackages)
+	}
+	r := RunResult{Tests: p.tests}
+	if got, want := r.TestSummary(), "1 of 2 tests failing: TestA"; got != want {
+		t.Errorf("r.TestSummary() = %q, want %q", got, want)
+	}
+}
+
+func Test_diff(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	t1 := t0.Add(time.Second)
+	old := map[string]fileState{
+		"same":    {modTime: t0, size: 1},
+		"touched": {modTime: t0, size: 1},
+		"resized": {modTime: t0, size: 1},
+		"hashed":  {modTime: t0, size: 1, sum: []byte{1}},
+		"chmod":   {modTime: t0, size: 1, mode: 0644},
+		"removed": {modTime: t0, size: 1},
+	}
+	new := map[string]fileState{
+		"same":    {modTime: t0, size: 1},
+		"touched": {modTime: t1, size: 1},
+		"resized": {modTime: t0, size: 2},
+		"hashed":  {modTime: t0, size: 1, sum: []byte{2}},
+		"chmod":   {modTime: t0, size: 1, mode: 0755},
+		"created": {modTime: t1, size: 1},
+	}
+	want := []Event{
+		{Path: "chmod", Op: Chmod},
+		{Path: "created", Op: Create},
+		{Path: "hashed", Op: Write},
+		{Path: "removed", Op: Remove},
+		{Path: "resized", Op: Write},
+		{Path: "touched", Op: Write},
+	}
+	if got := diff(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("diff(old, new) = %v, want %v", got, want)
+	}
+}
+
+func Test_statusLine(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start.Add(3*time.Minute + 1500*time.Millisecond)
+	s := State{Results: []RunResult{
+		{Error: errors.New("fail")},
+		{Error: ErrCancelled},
+		{Command: []string{"go", "test"}, Start: start, Duration: 1500 * time.Millisecond},
+		{Command: []string{"go", "test"}, Start: start, Duration: 1500 * time.Millisecond},
+	}}
+	tests := []struct {
+		width int
+		want  string
+	}{
+		{80, "#4  ✔×2  took 1.5s  3m ago  go test"},
+		{30, "#4  ✔×2  took 1.5s  3m ago"},
+		{12, "#4  ✔×2"},
+		{2, "#4"},
+		{1, ""},
+	}
+	for _, tt := range tests {
+		if got := s.statusLine(now, tt.width); got != tt.want {
+			t.Errorf("s.statusLine(now, %d) = %q, want %q", tt.width, got, tt.want)
+		}
+	}
+	s.Running = []RunEvent{{Spec: RunSpec{Command: []string{"make", "test"}}, Start: now.Add(-2500 * time.Millisecond)}}
+	if got := s.statusLine(now, 80); !strings.HasSuffix(got, " running 2s  #4  ✔×2  took 1.5s  3m ago  make test") {
+		t.Errorf("s.statusLine(now, 80) = %q, want running make test", got)
+	}
+	s.Running = nil
+	s.Rotation = Rotation{Participants: []string{"ana", "bo"}, Interval: 5 * time.Minute, Start: now.Add(-28 * time.Second)}
+	if got, want := s.statusLine(now, 80), "ana → bo 4:32  #4  ✔×2  took 1.5s  3m ago  go test"; got != want {
+		t.Errorf("s.statusLine(now, 80) = %q, want %q", got, want)
+	}
+}
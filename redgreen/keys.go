@@ -0,0 +1,185 @@
+package redgreen
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+)
+
+// An Action is something the user can do by pressing a key.
+type Action string
+
+// All actions, in the order they are listed in the help.
+const (
+	ActionRerun      Action = "rerun"
+	ActionPause      Action = "pause"
+	ActionOutput     Action = "output"
+	ActionHistory    Action = "history"
+	ActionScrollUp   Action = "scroll_up"
+	ActionScrollDown Action = "scroll_down"
+	ActionPageUp     Action = "page_up"
+	ActionPageDown   Action = "page_down"
+	ActionClear      Action = "clear"
+	ActionRotate     Action = "rotate"
+	ActionMute       Action = "mute"
+	ActionHelp       Action = "help"
+	ActionQuit       Action = "quit"
+)
+
+// Actions lists all actions, in the order they are listed in the help.
+var Actions = []Action{
+	ActionRerun,
+	ActionPause,
+	ActionOutput,
+	ActionHistory,
+	ActionScrollUp,
+	ActionScrollDown,
+	ActionPageUp,
+	ActionPageDown,
+	ActionClear,
+	ActionRotate,
+	ActionMute,
+	ActionHelp,
+	ActionQuit,
+}
+
+// actionDescriptions holds the descriptions of actions shown in the help.
+var actionDescriptions = map[Action]string{
+	ActionRerun:      "run all commands now",
+	ActionPause:      "pause or resume watching",
+	ActionOutput:     "show or hide output of selected run",
+	ActionHistory:    "show or hide history",
+	ActionScrollUp:   "scroll up or select older run",
+	ActionScrollDown: "scroll down or select newer run",
+	ActionPageUp:     "scroll up a page",
+	ActionPageDown:   "scroll down a page",
+	ActionClear:      "clear history",
+	ActionRotate:     "rotate pilots now",
+	ActionMute:       "mute or unmute sounds",
+	ActionHelp:       "show or hide this help",
+	ActionQuit:       "quit",
+}
+
+// A Key identifies a key press: either a special key, such as termbox.KeyEnter,
+// or a character.
+type Key struct {
+	Key termbox.Key
+	Ch  rune
+}
+
+// keyNames maps the names of special keys to keys.
+var keyNames = map[string]termbox.Key{
+	"enter":     termbox.KeyEnter,
+	"esc":       termbox.KeyEsc,
+	"space":     termbox.KeySpace,
+	"tab":       termbox.KeyTab,
+	"backspace": termbox.KeyBackspace2,
+	"up":        termbox.KeyArrowUp,
+	"down":      termbox.KeyArrowDown,
+	"left":      termbox.KeyArrowLeft,
+	"right":     termbox.KeyArrowRight,
+	"pgup":      termbox.KeyPgup,
+	"pgdn":      termbox.KeyPgdn,
+	"home":      termbox.KeyHome,
+	"end":       termbox.KeyEnd,
+}
+
+// ParseKey parses the name of a special key, such as "enter", "esc", "space",
+// "up" or "pgdn", or a single character, such as "r" or "?".
+func ParseKey(s string) (Key, error) {
+	if k, ok := keyNames[strings.ToLower(s)]; ok {
+		return Key{Key: k}, nil
+	}
+	if r, size := utf8.DecodeRuneInString(s); size == len(s) && r > ' ' && r != utf8.RuneError {
+		return Key{Ch: r}, nil
+	}
+	return Key{}, errors.New("invalid key " + strconv.Quote(s))
+}
+
+func (k Key) String() string {
+	if k.Ch != 0 {
+		return string(k.Ch)
+	}
+	for name, key := range keyNames {
+		if key == k.Key {
+			return name
+		}
+	}
+	return fmt.Sprintf("key %d", k.Key)
+}
+
+// A Keymap maps keys to actions.
+type Keymap map[Key]Action
+
+// DefaultKeymap returns a new Keymap with the default key bindings.
+func DefaultKeymap() Keymap {
+	return Keymap{
+		{Key: termbox.KeyEnter}:     ActionRerun,
+		{Ch: 'r'}:                   ActionRerun,
+		{Ch: 'a'}:                   ActionRerun,
+		{Ch: 'p'}:                   ActionPause,
+		{Ch: 'o'}:                   ActionOutput,
+		{Ch: 'h'}:                   ActionHistory,
+		{Key: termbox.KeyArrowUp}:   ActionScrollUp,
+		{Key: termbox.KeyArrowDown}: ActionScrollDown,
+		{Key: termbox.KeyPgup}:      ActionPageUp,
+		{Key: termbox.KeyPgdn}:      ActionPageDown,
+		{Ch: 'c'}:                   ActionClear,
+		{Ch: 'n'}:                   ActionRotate,
+		{Ch: 'm'}:                   ActionMute,
+		{Ch: '?'}:                   ActionHelp,
+		{Ch: 'q'}:                   ActionQuit,
+		{Key: termbox.KeyEsc}:       ActionQuit,
+	}
+}
+
+// Action returns the action bound to the key pressed in the termbox event e.
+func (m Keymap) Action(e termbox.Event) (Action, bool) {
+	if e.Type != termbox.EventKey {
+		return "", false
+	}
+	a, ok := m[Key{Key: e.Key, Ch: e.Ch}]
+	return a, ok
+}
+
+// Bind replaces the keys bound to action with keys.
+func (m Keymap) Bind(action Action, keys ...Key) {
+	for k, a := range m {
+		if a == action {
+			delete(m, k)
+		}
+	}
+	for _, k := range keys {
+		m[k] = action
+	}
+}
+
+// Help returns one line for each action with its keys and description.
+func (m Keymap) Help() []string {
+	keys := make(map[Action][]string)
+	width := 0
+	for _, a := range Actions {
+		for k, ka := range m {
+			if ka == a {
+				keys[a] = append(keys[a], k.String())
+			}
+		}
+		sort.Strings(keys[a])
+		if n := len(strings.Join(keys[a], ", ")); n > width {
+			width = n
+		}
+	}
+	var lines []string
+	for _, a := range Actions {
+		if len(keys[a]) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%-*s  %s", width, strings.Join(keys[a], ", "), actionDescriptions[a]))
+	}
+	return lines
+}
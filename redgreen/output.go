@@ -0,0 +1,41 @@
+package redgreen
+
+// A tailBuffer is an io.Writer that keeps only the last bytes written to it, up
+// to a limit.
+type tailBuffer struct {
+	buf       []byte
+	limit     int
+	truncated bool
+}
+
+// newTailBuffer returns a tailBuffer that keeps at most limit bytes. A negative
+// limit means no limit.
+func newTailBuffer(limit int) *tailBuffer {
+	return &tailBuffer{limit: limit}
+}
+
+// Write appends p to the buffer, discarding old data as needed. It never
+// returns an error.
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	// Discard old data only after the buffer has grown to twice the limit,
+	// to avoid copying on every write.
+	if b.limit >= 0 && len(b.buf) > 2*b.limit {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.limit:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// Bytes returns the last bytes written to the buffer, up to the limit.
+func (b *tailBuffer) Bytes() []byte {
+	if b.limit >= 0 && len(b.buf) > b.limit {
+		return b.buf[len(b.buf)-b.limit:]
+	}
+	return b.buf
+}
+
+// Truncated reports whether any data written to the buffer was discarded.
+func (b *tailBuffer) Truncated() bool {
+	return b.truncated || (b.limit >= 0 && len(b.buf) > b.limit)
+}
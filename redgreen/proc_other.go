@@ -0,0 +1,20 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package redgreen
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// setProcessGroup is a no-op on systems without process groups.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup kills p immediately. Descendants of p are not killed
+// on systems without process groups, and release does nothing.
+func terminateProcessGroup(p *os.Process, grace time.Duration) (release func()) {
+	p.Kill()
+	return func() {}
+}
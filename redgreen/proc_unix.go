@@ -0,0 +1,36 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package redgreen
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup configures cmd to start in a new process group, so that it
+// can be terminated along with all of its descendants.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateProcessGroup sends SIGTERM to the process group led by p, and
+// SIGKILL after the grace period, so that all processes in the group are
+// terminated even if they ignore SIGTERM or the leader exits first. Once p has
+// been waited for, release must be called to cancel the SIGKILL if no process
+// is left in the group, since the group ID may then be reused.
+func terminateProcessGroup(p *os.Process, grace time.Duration) (release func()) {
+	pgid := -p.Pid
+	syscall.Kill(pgid, syscall.SIGTERM)
+	t := time.AfterFunc(grace, func() {
+		syscall.Kill(pgid, syscall.SIGKILL)
+	})
+	return func() {
+		// Fails with ESRCH if the whole group has already exited.
+		if err := syscall.Kill(pgid, 0); err != nil {
+			t.Stop()
+		}
+	}
+}
@@ -0,0 +1,82 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package redgreen
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_runTerminatesDescendants(t *testing.T) {
+	// The shell prints the PID of a child process and waits for it.
+	const (
+		script           = "sleep 30 & echo $!; wait"
+		scriptIgnoreTERM = "trap '' TERM; " + script
+	)
+	tests := []struct {
+		name   string
+		script string
+		cancel bool
+	}{
+		{name: "timeout", script: script},
+		{name: "timeout, SIGTERM ignored", script: scriptIgnoreTERM},
+		{name: "cancel", script: script, cancel: true},
+		{name: "cancel, SIGTERM ignored", script: scriptIgnoreTERM, cancel: true},
+	}
+	for _, tt := range tests {
+		spec := RunSpec{
+			Command:     []string{"sh", "-c", tt.script},
+			GracePeriod: 100 * time.Millisecond,
+		}
+		cancel := make(chan struct{})
+		if tt.cancel {
+			time.AfterFunc(200*time.Millisecond, func() { close(cancel) })
+		} else {
+			spec.Timeout = 200 * time.Millisecond
+		}
+		start := time.Now()
+		r := run(spec, cancel, false)
+		// The child process holds the output pipe open, so run cannot
+		// return before the child terminates.
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: run(%v) took %v, child process not terminated", tt.name, spec.Command, elapsed)
+		}
+		if r.Error == nil {
+			t.Errorf("%s: run(%v): got nil error", tt.name, spec.Command)
+			continue
+		}
+		var pid int
+		if _, err := fmt.Sscan(string(r.CombinedOutput), &pid); err != nil {
+			t.Errorf("%s: cannot parse PID from output %q: %v", tt.name, r.CombinedOutput, err)
+			continue
+		}
+		deadline := time.Now().Add(time.Second)
+		for processAlive(pid) {
+			if time.Now().After(deadline) {
+				syscall.Kill(pid, syscall.SIGKILL)
+				t.Errorf("%s: child process %d still alive", tt.name, pid)
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// processAlive reports whether the process with the given PID is running.
+func processAlive(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+	// A zombie process has terminated, but exists until its parent waits
+	// for it.
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err == nil && strings.Contains(string(b), ") Z ") {
+		return false
+	}
+	return true
+}
@@ -1,56 +1,196 @@
 package redgreen
 
 import (
-	"bytes"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
+	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
 )
 
 // RunSpec holds the specification of a command to be run.
 type RunSpec struct {
+	// Name identifies the command when there are multiple commands.
+	Name    string
 	Command []string
-	Timeout time.Duration
+	// Patterns holds patterns of files whose changes trigger the command,
+	// see WatchSpec.Include. Run does not use it.
+	Patterns []string
+	Timeout  time.Duration
+	// OutputLimit is the maximum number of bytes of output to keep. Only
+	// the last OutputLimit bytes are kept. Zero means DefaultOutputLimit,
+	// and a negative value means no limit.
+	OutputLimit int
+	// Restart enables cancelling the command when a new spec is received
+	// while it is running, see Run.
+	Restart bool
+	// GracePeriod is how long to wait for the command and its descendants
+	// to terminate after SIGTERM before sending SIGKILL, when they are
+	// terminated because of timeout or cancellation. Zero means
+	// DefaultGracePeriod.
+	GracePeriod time.Duration
+	// Trigger holds the file system events that caused the command to
+	// run, if any. Run does not use it other than to copy it to the result.
+	Trigger []Event
+	// Partial means that Command runs only part of the tests, such as
+	// the tests of the packages affected by changes, see State.Latest. Run
+	// does not use it other than to copy it to the result.
+	Partial bool
 }
 
+// DefaultGracePeriod is the default grace period for terminating commands.
+const DefaultGracePeriod = 2 * time.Second
+
+// DefaultOutputLimit is the default maximum number of bytes of output kept for
+// each command execution.
+const DefaultOutputLimit = 1 << 20
+
 // RunResult holds information about a command execution.
 type RunResult struct {
+	// Name is the name of the spec of the command.
+	Name string
+	// Command, Trigger and Partial are copied from the spec of the
+	// command.
+	Command []string
+	Trigger []Event
+	Partial bool
+	// Start is when the command started and Duration is how long it ran.
+	Start    time.Time
+	Duration time.Duration
+	// Pilot is the name of the person at the keyboard when the command
+	// ran, if known. Run does not set it.
+	Pilot string
 	Error error
-	// CombinedOutput []byte
+	// CombinedOutput holds what the command wrote to its standard output
+	// and standard error.
+	CombinedOutput []byte
+	// Truncated reports whether the beginning of the output was discarded
+	// because it exceeded the output limit.
+	Truncated bool
+	// Packages and Tests hold the outcome of each package and test, if
+	// the command is go test -json. The output of go test -json is
+	// translated to plain text in CombinedOutput.
+	Packages []TestResult
+	Tests    []TestResult
 }
 
 // Run runs commands coming from the in channel in a new goroutine and returns a
 // channel of results of each execution. Input and output is synchronized, a new
 // command will be executed only after the error returned by the previous
-// execution is consumed downstream. Closing either done or in signals that no
-// more commands are to be run, and, consequently, the output channel will be
-// closed.
+// execution is consumed downstream. As an exception, if the running spec has
+// Restart set, a new spec with the same name received from in cancels the
+// running command, and the new spec runs after the cancelled result is
+// consumed. Specs with other names received meanwhile are queued, keeping only
+// the latest spec for each name. Closing either done or in signals that no more
+// commands are to be run, and, consequently, the output channel will be
+// closed. Closing done also cancels the running command.
 func Run(done <-chan struct{}, in <-chan RunSpec) <-chan RunResult {
+	events := RunEvents(done, in)
 	out := make(chan RunResult)
 	go func() {
 		defer close(out)
-		for {
+		for ev := range events {
+			if ev.Result == nil {
+				continue
+			}
 			select {
-			case spec, ok := <-in:
-				if !ok {
+			case out <- *ev.Result:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// A RunEvent is sent by RunEvents when a command starts, and again when it
+// terminates.
+type RunEvent struct {
+	Spec RunSpec
+	// Start is when the command started.
+	Start time.Time
+	// Result is nil when the command has just started.
+	Result *RunResult
+}
+
+// RunEvents is like Run, but also sends an event before starting each command,
+// so that consumers can tell when a command is running. Commands start only
+// after their start event is consumed.
+func RunEvents(done <-chan struct{}, in <-chan RunSpec) <-chan RunEvent {
+	out := make(chan RunEvent)
+	go func() {
+		defer close(out)
+		// FIXME: expose the debug flag properly.
+		debugFlag := flag.Lookup("debug")
+		debug := debugFlag != nil && debugFlag.Value.String() == "true"
+		// queue holds specs received while running a command.
+		var queue []RunSpec
+		for {
+			var spec RunSpec
+			if len(queue) > 0 {
+				spec, queue = queue[0], queue[1:]
+			} else {
+				select {
+				case s, ok := <-in:
+					if !ok {
+						return
+					}
+					spec = s
+				case <-done:
 					return
 				}
-				// FIXME: expose the debug flag properly.
-				debugFlag := flag.Lookup("debug")
-				debug := debugFlag != nil && debugFlag.Value.String() == "true"
-				r := RunResult{run(spec.Command, spec.Timeout, debug)}
+			}
+			select {
+			case out <- RunEvent{Spec: spec, Start: time.Now()}:
+			case <-done:
+				return
+			}
+			cancel := make(chan struct{})
+			result := make(chan RunResult, 1)
+			go func() {
+				result <- run(spec, cancel, debug)
+			}()
+			// restart is nil, blocking forever, unless we should
+			// receive specs while running.
+			var restart <-chan RunSpec
+			if spec.Restart {
+				restart = in
+			}
+			var r RunResult
+		wait:
+			for {
 				select {
-				case out <- r:
+				case r = <-result:
+					break wait
+				case s, ok := <-restart:
+					if !ok {
+						restart = nil
+						continue
+					}
+					if s.Name != spec.Name {
+						queue = enqueue(queue, s)
+						continue
+					}
+					queue = append([]RunSpec{s}, dequeue(queue, s.Name)...)
+					close(cancel)
+					r = <-result
+					break wait
 				case <-done:
-					return
+					close(cancel)
+					r = <-result
+					break wait
 				}
+			}
+			select {
+			case out <- RunEvent{Spec: spec, Start: r.Start, Result: &r}:
 			case <-done:
 				return
 			}
@@ -59,32 +199,215 @@ func Run(done <-chan struct{}, in <-chan RunSpec) <-chan RunResult {
 	return out
 }
 
-// run runs command and waits for it to terminate for at most timeout. Zero or
-// negative timeout means no timeout.
-func run(command []string, timeout time.Duration, debug bool) (err error) {
+// enqueue appends spec to queue, unless there is a spec with the same name in
+// queue, in which case it is replaced by spec.
+func enqueue(queue []RunSpec, spec RunSpec) []RunSpec {
+	for i := range queue {
+		if queue[i].Name == spec.Name {
+			queue[i] = spec
+			return queue
+		}
+	}
+	return append(queue, spec)
+}
+
+// dequeue removes the spec with the given name from queue, if any.
+func dequeue(queue []RunSpec, name string) []RunSpec {
+	for i := range queue {
+		if queue[i].Name == name {
+			return append(queue[:i:i], queue[i+1:]...)
+		}
+	}
+	return queue
+}
+
+// TriggerSummary returns a short description of the file system events that
+// caused the command to run, or an empty string if there are none.
+func (r RunResult) TriggerSummary() string {
+	if len(r.Trigger) == 0 {
+		return ""
+	}
+	const maxNames = 3
+	var names []string
+	for i, ev := range r.Trigger {
+		if i == maxNames {
+			names = append(names, fmt.Sprintf("and %d more", len(r.Trigger)-maxNames))
+			break
+		}
+		names = append(names, filepath.Base(ev.Path))
+	}
+	return "triggered by " + strings.Join(names, ", ")
+}
+
+// End returns when the command terminated.
+func (r RunResult) End() time.Time {
+	return r.Start.Add(r.Duration)
+}
+
+// HistoryLine returns a one-line description of the command execution: when it
+// started, how long it took, its status, its name and what triggered it.
+func (r RunResult) HistoryLine() string {
+	status := r.Status()
+	line := fmt.Sprintf("%s %7s %c %-8s", r.Start.Format("15:04:05"), formatDuration(r.Duration), status.Glyph(), status)
+	if r.Name != "" {
+		line += " " + r.Name
+	}
+	if len(r.Trigger) > 0 {
+		var names []string
+		for _, ev := range r.Trigger {
+			names = append(names, filepath.Base(ev.Path))
+		}
+		line += " ← " + strings.Join(names, ", ")
+	}
+	return line
+}
+
+// ErrCancelled is the error of a command execution that was cancelled before
+// the command terminated.
+var ErrCancelled = errors.New("cancelled")
+
+// run runs the command in spec and waits for it to terminate for at most
+// spec.Timeout. Zero or negative timeout means no timeout. The command is
+// started in a new process group, and the whole group is terminated on timeout
+// or when cancel is closed.
+func run(spec RunSpec, cancel <-chan struct{}, debug bool) (r RunResult) {
+	r.Name, r.Command, r.Trigger, r.Partial = spec.Name, spec.Command, spec.Trigger, spec.Partial
+	r.Start = time.Now()
+	defer func() { r.Duration = time.Since(r.Start) }()
+	command, timeout := spec.Command, spec.Timeout
 	if len(command) == 0 {
-		return errors.New("command must not be empty")
+		r.Error = errors.New("command must not be empty")
+		return r
 	}
 	cmd := exec.Command(command[0], command[1:]...)
+	setProcessGroup(cmd)
+	limit := spec.OutputLimit
+	if limit == 0 {
+		limit = DefaultOutputLimit
+	}
+	b := newTailBuffer(limit)
+	// Using the same writer for both standard output and error ensures
+	// their relative ordering is preserved.
+	p := newTestParser(b)
+	cmd.Stdout = p
+	cmd.Stderr = p
 	if debug {
 		log.Printf("running: %s", strings.Join(cmd.Args, " "))
-		var b bytes.Buffer
-		cmd.Stdout = &b
-		cmd.Stderr = &b
 		defer func() {
-			log.Printf("output:\n%s", b.String())
-			if err != nil {
-				log.Println("error:", err)
+			log.Printf("output:\n%s", r.CombinedOutput)
+			if r.Error != nil {
+				log.Println("error:", r.Error)
 			}
 		}()
 	}
+	defer func() {
+		r.CombinedOutput, r.Truncated = b.Bytes(), b.Truncated()
+		r.Packages, r.Tests = p.packages, p.tests
+	}()
 	if err := cmd.Start(); err != nil {
-		return err
+		r.Error = err
+		return r
 	}
+	grace := spec.GracePeriod
+	if grace == 0 {
+		grace = DefaultGracePeriod
+	}
+	// The command may be terminated both on timeout and on cancel. mu
+	// guards waited and releases, see terminateProcessGroup.
+	var (
+		mu       sync.Mutex
+		waited   bool
+		releases []func()
+	)
+	terminate := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !waited {
+			releases = append(releases, terminateProcessGroup(cmd.Process, grace))
+		}
+	}
+	// timedOut is closed if the command is terminated after timeout.
+	timedOut := make(chan struct{})
 	if timeout > 0 {
-		defer time.AfterFunc(timeout, func() { cmd.Process.Kill() }).Stop()
+		defer time.AfterFunc(timeout, func() {
+			close(timedOut)
+			terminate()
+		}).Stop()
+	}
+	// cancelled is closed if the command is terminated because of cancel.
+	cancelled := make(chan struct{})
+	// exited is closed when the command terminates.
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-cancel:
+			close(cancelled)
+			terminate()
+		case <-exited:
+		}
+	}()
+	r.Error = cmd.Wait()
+	close(exited)
+	mu.Lock()
+	waited = true
+	for _, release := range releases {
+		release()
 	}
-	return cmd.Wait()
+	mu.Unlock()
+	p.Flush()
+	if r.Error == nil {
+		return r
+	}
+	select {
+	case <-cancelled:
+		r.Error = ErrCancelled
+		return r
+	default:
+	}
+	select {
+	case <-timedOut:
+		r.Error = &TimeoutError{Timeout: timeout, Err: r.Error}
+	default:
+		if p.buildFailed {
+			r.Error = &BuildError{Err: r.Error}
+		}
+	}
+	return r
+}
+
+// WatchSpec holds the specification of file system paths to be watched.
+type WatchSpec struct {
+	Paths []string
+	// Delay is the debounce delay, see Watch.
+	Delay time.Duration
+	// Recursive enables watching all directories under Paths, including
+	// directories created after the watch has started.
+	Recursive bool
+	// Ignore holds patterns of paths that should not trigger events, using
+	// the syntax of .gitignore files. Ignored directories are not watched
+	// in recursive mode.
+	Ignore []string
+	// Include holds patterns of files that should trigger events. If empty,
+	// all files that are not ignored trigger events.
+	Include []string
+	// GitIgnore enables ignoring paths according to the .gitignore files
+	// found in the watched directories.
+	GitIgnore bool
+	// Backend selects how changes are detected. The zero value uses file
+	// system notifications, falling back to polling if they are not
+	// available.
+	Backend Backend
+	// PollInterval is the interval between scans when polling. If zero,
+	// DefaultPollInterval is used.
+	PollInterval time.Duration
+	// PollHash enables comparing the contents of files when polling, see
+	// NewPollWatcher.
+	PollHash bool
+	// ContentHash enables skipping events for files whose contents did not
+	// change since the previous batch of events that included them, such
+	// as when an editor saves a file without modifications. The contents
+	// of existing files are recorded when watching starts.
+	ContentHash bool
 }
 
 // Watch returns a channel that will be sent to after file system events in path
@@ -94,42 +417,188 @@ func run(command []string, timeout time.Duration, debug bool) (err error) {
 // automatically gofmt'ed. Closing done interrupts the file system watcher and
 // closes the output channel, freeing all allocated resources.
 func Watch(done <-chan struct{}, path string, delay time.Duration) (<-chan struct{}, error) {
-	watcher, err := fsnotify.NewWatcher()
+	return WatchSpec{Paths: []string{path}, Delay: delay}.Watch(done)
+}
+
+// Watch is like the package-level Watch function, but watches all paths in
+// spec, optionally recursively, and filters events according to spec.
+func (spec WatchSpec) Watch(done <-chan struct{}) (<-chan struct{}, error) {
+	paths, err := spec.WatchPaths(done)
 	if err != nil {
-		return nil, fmt.Errorf("create file system watcher: %v", err)
+		return nil, err
 	}
-	err = watcher.Add(path)
+	out := make(chan struct{})
+	go func() {
+		defer close(out)
+		for range paths {
+			select {
+			case out <- struct{}{}:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchPaths is like Watch, but sends the paths that changed, without
+// duplicates. See WatchEvents.
+func (spec WatchSpec) WatchPaths(done <-chan struct{}) (<-chan []string, error) {
+	events, err := spec.WatchEvents(done)
 	if err != nil {
-		return nil, fmt.Errorf("add path %q to file system watcher: %v", path, err)
+		return nil, err
 	}
-	out := make(chan struct{})
+	out := make(chan []string)
 	go func() {
 		defer close(out)
-		defer watcher.Close()
-		// timer holds a scheduled send to out.
-		var timer *time.Timer
-		// stopTimer stops the timer if non-nil.
-		stopTimer := func() {
-			if timer != nil {
-				timer.Stop()
+		for batch := range events {
+			paths := make([]string, len(batch))
+			for i, ev := range batch {
+				paths[i] = ev.Path
+			}
+			select {
+			case out <- paths:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// An Event represents changes to a file system path.
+type Event struct {
+	Path string `json:"path"`
+	Op   Op     `json:"op"`
+}
+
+func (ev Event) String() string {
+	return fmt.Sprintf("%s: %v", ev.Path, ev.Op)
+}
+
+// Op describes a set of file system operations.
+type Op uint32
+
+// File system operations.
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+	Rename
+	Chmod
+)
+
+// opNames holds the names of operations, in the order they are listed by
+// Op.String.
+var opNames = []struct {
+	op   Op
+	name string
+}{
+	{Create, "CREATE"},
+	{Write, "WRITE"},
+	{Remove, "REMOVE"},
+	{Rename, "RENAME"},
+	{Chmod, "CHMOD"},
+}
+
+func (op Op) String() string {
+	var names []string
+	for _, o := range opNames {
+		if op&o.op != 0 {
+			names = append(names, o.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (op Op) MarshalText() ([]byte, error) {
+	return []byte(op.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, parsing the format of
+// Op.String.
+func (op *Op) UnmarshalText(text []byte) error {
+	*op = 0
+	if len(text) == 0 {
+		return nil
+	}
+next:
+	for _, name := range strings.Split(string(text), "|") {
+		for _, o := range opNames {
+			if o.name == name {
+				*op |= o.op
+				continue next
 			}
 		}
-		// Abort any previously scheduled send before returning.
-		defer stopTimer()
+		return fmt.Errorf("unknown file system operation %q", name)
+	}
+	return nil
+}
+
+// WatchEvents is like Watch, but sends the events that happened within each
+// delay. Events are coalesced by path, so that each path appears only once in
+// a batch, with all the operations that happened to it. If sending is
+// blocked, events that happen meanwhile are added to the pending batch.
+func (spec WatchSpec) WatchEvents(done <-chan struct{}) (<-chan []Event, error) {
+	// dirs holds the set of directories added to the watcher in recursive
+	// mode. After setup, it is only accessed by the goroutine below.
+	dirs := make(map[string]bool)
+	f := newFilter(spec)
+	watcher, err := spec.newWatcher(dirs, f)
+	if err != nil {
+		return nil, err
+	}
+	// contents is nil unless spec.ContentHash is set.
+	var contents contentCache
+	if spec.ContentHash {
+		contents = make(contentCache)
+		contents.fill(spec.Paths, spec.Recursive, f)
+	}
+	out := make(chan []Event)
+	go func() {
+		defer close(out)
+		defer watcher.Close()
+		var (
+			// batch holds events that happened within the delay.
+			batch []Event
+			// delay is nil, blocking forever, unless a batch is
+			// being collected.
+			delay <-chan time.Time
+			// pending holds events ready to be sent to out.
+			pending []Event
+		)
 		for {
+			// send is nil, blocking forever, unless there are
+			// pending events.
+			var send chan<- []Event
+			if len(pending) > 0 {
+				send = out
+			}
 			select {
-			case <-watcher.Events:
-				// Abort any previously scheduled send.
-				stopTimer()
-				// Schedule send to out.
-				timer = time.AfterFunc(delay, func() {
-					select {
-					case out <- struct{}{}:
-					case <-done:
-						return
+			case ev := <-watcher.Events():
+				f.invalidate(ev.Path)
+				relevant := f.relevant(ev.Path, isDir(ev.Path, dirs))
+				if spec.Recursive {
+					updateTree(watcher, dirs, f, ev)
+				}
+				if !relevant {
+					continue
+				}
+				batch = Coalesce(batch, ev)
+				// Restart the delay.
+				delay = time.After(spec.Delay)
+			case <-delay:
+				for _, ev := range batch {
+					if contents != nil && !contents.changed(ev.Path) {
+						continue
 					}
-				})
-			case err := <-watcher.Errors:
+					pending = Coalesce(pending, ev)
+				}
+				batch, delay = nil, nil
+			case send <- pending:
+				pending = nil
+			case err := <-watcher.Errors():
 				log.Println("ERROR:", err)
 			case <-done:
 				return
@@ -139,29 +608,284 @@ func Watch(done <-chan struct{}, path string, delay time.Duration) (<-chan struc
 	return out, nil
 }
 
+// newWatcher returns a Watcher for spec.Backend watching all paths in spec,
+// recording in dirs the directories added in recursive mode. With BackendAuto,
+// it falls back to polling if file system notifications cannot be set up for
+// reasons other than missing paths, for instance because the inotify watch
+// limit was reached.
+func (spec WatchSpec) newWatcher(dirs map[string]bool, f *filter) (Watcher, error) {
+	if spec.Backend != BackendPoll {
+		w, err := newNotifyWatcher()
+		if err != nil {
+			err = fmt.Errorf("create file system watcher: %w", err)
+		} else if err = spec.addPaths(w, dirs, f); err != nil {
+			w.Close()
+		} else {
+			return w, nil
+		}
+		if spec.Backend == BackendNotify || errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		log.Printf("WARNING: %v; polling for changes", err)
+		for dir := range dirs {
+			delete(dirs, dir)
+		}
+	}
+	interval := spec.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	w := NewPollWatcher(interval, spec.PollHash)
+	if err := spec.addPaths(w, dirs, f); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// addPaths adds all paths in spec to watcher, recursively if spec.Recursive is
+// set.
+func (spec WatchSpec) addPaths(watcher Watcher, dirs map[string]bool, f *filter) error {
+	for _, path := range spec.Paths {
+		var err error
+		if spec.Recursive {
+			err = addTree(watcher, dirs, f, path)
+		} else {
+			err = watcher.Add(path)
+		}
+		if err != nil {
+			return fmt.Errorf("add path %q to file system watcher: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Coalesce adds more to events, merging each of them with an existing event
+// for the same path, if any.
+func Coalesce(events []Event, more ...Event) []Event {
+next:
+	for _, ev := range more {
+		for i := range events {
+			if events[i].Path == ev.Path {
+				events[i].Op |= ev.Op
+				continue next
+			}
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+// isDir reports whether name is a directory. Since name may no longer exist,
+// the set of known directories dirs is consulted as a fallback.
+func isDir(name string, dirs map[string]bool) bool {
+	if info, err := os.Lstat(name); err == nil {
+		return info.IsDir()
+	}
+	return dirs[filepath.Clean(name)]
+}
+
+// addTree adds root and all directories under it that are not ignored by f to
+// watcher, recording them in dirs. If root is not a directory, only root is
+// added.
+func addTree(watcher Watcher, dirs map[string]bool, f *filter, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if path == root {
+				return watcher.Add(path)
+			}
+			return nil
+		}
+		if f.ignored(path, true) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return err
+		}
+		dirs[filepath.Clean(path)] = true
+		return nil
+	})
+}
+
+// updateTree keeps the set of watched directories in sync with the file system
+// after ev: new directories are watched and removed or renamed ones, including
+// everything under them, are no longer watched.
+func updateTree(watcher Watcher, dirs map[string]bool, f *filter, ev Event) {
+	name := filepath.Clean(ev.Path)
+	if ev.Op&(Remove|Rename) != 0 {
+		prefix := name + string(filepath.Separator)
+		for dir := range dirs {
+			if dir == name || strings.HasPrefix(dir, prefix) {
+				// The watch may have been removed already by the
+				// kernel, ignore errors.
+				watcher.Remove(dir)
+				delete(dirs, dir)
+			}
+		}
+	}
+	if ev.Op&Create != 0 {
+		if info, err := os.Stat(name); err == nil && info.IsDir() {
+			// The new directory may disappear while we walk it, in
+			// which case a Remove event will follow.
+			if err := addTree(watcher, dirs, f, name); err != nil && !os.IsNotExist(err) {
+				log.Println("ERROR:", err)
+			}
+		}
+	}
+}
+
 // State represents the program state that can be rendered to the screen. If
 // Debug is false, termbox must have been initialized. If Debug is true, termbox
 // is not used.
 type State struct {
 	Results []RunResult
 	Debug   bool
+	// ShowOutput enables showing the output of the selected command
+	// execution.
+	ShowOutput bool
+	// OutputScroll is the number of lines the output is scrolled up from
+	// its end.
+	OutputScroll int
+	// ShowHistory enables showing a list of all command executions.
+	ShowHistory bool
+	// Selected is the number of results between the selected result and
+	// the last one. The zero value selects the last result.
+	Selected int
+	// Colors overrides the color that represents each status.
+	Colors map[Status]Color
+	// Running holds the start events of the commands that are running,
+	// see RunEvents.
+	Running []RunEvent
+	// Paused means that commands are not run when files change.
+	Paused bool
+	// Rotation is the rotation of pilots, if any.
+	Rotation Rotation
+	// Muted means that no sounds are played on transitions.
+	Muted bool
+	// ShowHelp enables showing Help, typically a description of the
+	// available keys.
+	ShowHelp bool
+	Help     []string
 }
 
-// Color returns the color that represents the state. There are three possible
-// colors: ColorRed means the last test command failed, ColorGreen means the
-// last test command succeeded, and ColorYellow means the state is unknown.
-func (s State) Color() Color {
-	var color Color
-	if len(s.Results) == 0 {
-		color = ColorYellow
-	} else {
-		if s.Results[len(s.Results)-1].Error == nil {
-			color = ColorGreen
-		} else {
-			color = ColorRed
+// statusColor returns the color that represents status.
+func (s State) statusColor(status Status) Color {
+	if c, ok := s.Colors[status]; ok {
+		return c
+	}
+	return status.Color()
+}
+
+// AddResult adds r to s.Results, keeping the same result selected unless the
+// last one was selected.
+func (s *State) AddResult(r RunResult) {
+	s.Results = append(s.Results, r)
+	if s.Selected > 0 {
+		s.Selected++
+	}
+}
+
+// SelectedResult returns the selected result, see State.Selected. It returns
+// false if there are no results.
+func (s State) SelectedResult() (RunResult, bool) {
+	i := len(s.Results) - 1 - s.Selected
+	if i < 0 || i >= len(s.Results) {
+		return RunResult{}, false
+	}
+	return s.Results[i], true
+}
+
+// Select moves the selection n results back, towards older results, or
+// forward if n is negative, and scrolls the output back to its end.
+func (s *State) Select(n int) {
+	s.Selected += n
+	if max := len(s.Results) - 1; s.Selected > max {
+		s.Selected = max
+	}
+	if s.Selected < 0 {
+		s.Selected = 0
+	}
+	s.OutputScroll = 0
+}
+
+// outputLines returns the lines of output of the selected command execution.
+func (s State) outputLines() []string {
+	r, ok := s.SelectedResult()
+	if !ok {
+		return nil
+	}
+	out := strings.TrimRight(string(r.CombinedOutput), "\n")
+	if out == "" {
+		return nil
+	}
+	return strings.Split(out, "\n")
+}
+
+// ScrollOutput scrolls the output of the selected command execution by n lines.
+// Positive values scroll up, towards the beginning of the output, and negative
+// values scroll down.
+func (s *State) ScrollOutput(n int) {
+	s.OutputScroll += n
+	if max := len(s.outputLines()) - 1; s.OutputScroll > max {
+		s.OutputScroll = max
+	}
+	if s.OutputScroll < 0 {
+		s.OutputScroll = 0
+	}
+}
+
+// Latest returns the last result that was not cancelled of each named
+// command, in the order the commands first appear in s.Results. A partial
+// result does not replace a worse one, since the failures may be in tests it
+// did not run, see RunSpec.Partial.
+func (s State) Latest() []RunResult {
+	var latest []RunResult
+	index := make(map[string]int)
+	for _, r := range s.Results {
+		if r.Status() == StatusCancelled {
+			continue
 		}
+		if i, ok := index[r.Name]; ok {
+			if !r.Partial || !latest[i].Status().worse(r.Status()) {
+				latest[i] = r
+			}
+			continue
+		}
+		index[r.Name] = len(latest)
+		latest = append(latest, r)
+	}
+	return latest
+}
+
+// Status returns the worst status among the latest results of each command. It
+// returns false if there are no results.
+func (s State) Status() (Status, bool) {
+	latest := s.Latest()
+	if len(latest) == 0 {
+		return 0, false
+	}
+	status := latest[0].Status()
+	for _, r := range latest[1:] {
+		if st := r.Status(); st.worse(status) {
+			status = st
+		}
+	}
+	return status, true
+}
+
+// Color returns the color that represents the state. ColorYellow means the
+// state is unknown, otherwise the color represents the worst status among the
+// latest results of each command, ignoring cancelled results, see Status.Color
+// and State.Colors.
+func (s State) Color() Color {
+	status, ok := s.Status()
+	if !ok {
+		return ColorYellow
 	}
-	return color
+	return s.statusColor(status)
 }
 
 // A Color represents the state of the program.
@@ -169,9 +893,15 @@ type Color termbox.Attribute
 
 // All possible colors.
 const (
-	ColorRed    = Color(termbox.ColorRed)
-	ColorGreen  = Color(termbox.ColorGreen)
-	ColorYellow = Color(termbox.ColorYellow)
+	ColorRed     = Color(termbox.ColorRed)
+	ColorGreen   = Color(termbox.ColorGreen)
+	ColorYellow  = Color(termbox.ColorYellow)
+	ColorBlue    = Color(termbox.ColorBlue)
+	ColorMagenta = Color(termbox.ColorMagenta)
+	ColorCyan    = Color(termbox.ColorCyan)
+	ColorWhite   = Color(termbox.ColorWhite)
+	// ColorGray is only available in 256-color mode.
+	ColorGray = Color(245)
 )
 
 func (c Color) String() string {
@@ -182,6 +912,16 @@ func (c Color) String() string {
 		return "green"
 	case ColorYellow:
 		return "yellow"
+	case ColorBlue:
+		return "blue"
+	case ColorMagenta:
+		return "magenta"
+	case ColorCyan:
+		return "cyan"
+	case ColorWhite:
+		return "white"
+	case ColorGray:
+		return "gray"
 	default:
 		return "unknown"
 	}
@@ -206,28 +946,205 @@ func Render(done <-chan struct{}, in <-chan State) {
 // render updates the screen according to s.
 func render(s State) {
 	color := s.Color()
+	if s.Paused {
+		color = ColorGray
+	}
 	if s.Debug {
 		log.Printf("render: %v", color)
 	} else {
 		termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
 		buf := termbox.CellBuffer()
-		w, _ := termbox.Size()
+		w, h := termbox.Size()
 		for i := range buf[:w] {
 			k := len(s.Results) - i - 1
 			if k < 0 {
 				break
 			}
-			if err := s.Results[k].Error; err == nil {
-				buf[i].Fg = termbox.ColorGreen
-				buf[i].Ch = '✔'
-			} else {
-				buf[i].Fg = termbox.ColorRed
-				buf[i].Ch = '✘'
-			}
+			status := s.Results[k].Status()
+			buf[i].Fg = termbox.Attribute(s.statusColor(status))
+			buf[i].Ch = status.Glyph()
 		}
 		for i := range buf[w:] {
 			buf[w+i].Bg = termbox.Attribute(color)
 		}
+		// The last row is reserved for the status line, other rows
+		// are only drawn if there is space left.
+		fg, bg := termbox.Attribute(termbox.ColorBlack), termbox.Attribute(color)
+		if len(s.Running) > 0 {
+			renderRunning(s.Running[0].Start, time.Now())
+		}
+		if latest := s.Latest(); len(latest) > 1 && 1 < h-1 {
+			x := 1
+			for _, r := range latest {
+				status := r.Status()
+				x = drawText(x, 1, w-1, fmt.Sprintf("%c %s  ", status.Glyph(), r.Name), fg, bg)
+			}
+		}
+		if len(s.Results) > 0 {
+			last := s.Results[len(s.Results)-1]
+			if 2 < h-1 {
+				drawText(1, 2, w-1, last.TestSummary(), fg, bg)
+			}
+			if 3 < h-1 {
+				drawText(1, 3, w-1, last.TriggerSummary(), fg, bg)
+			}
+		}
+		if s.Paused {
+			const label = "PAUSED"
+			drawText((w-len(label))/2, h/2, w, label, fg|termbox.AttrBold, bg)
+		}
+		if now := time.Now(); s.Rotation.Alerting(now) {
+			label := fmt.Sprintf(" Rotate! Pilot: %s, copilot: %s ", s.Rotation.Pilot(now), s.Rotation.Copilot(now))
+			// Flash the label twice a second.
+			lfg, lbg := termbox.Attribute(termbox.ColorWhite)|termbox.AttrBold, termbox.Attribute(termbox.ColorBlack)
+			if now.UnixNano()/int64(500*time.Millisecond)%2 == 0 {
+				lfg, lbg = termbox.ColorBlack|termbox.AttrBold, termbox.ColorWhite
+			}
+			drawText((w-runewidth.StringWidth(label))/2, h/2, w, label, lfg, lbg)
+		}
+		if h == 1 {
+			// Draw the status line to the right of the glyphs of
+			// the latest results.
+			line := s.statusLine(time.Now(), w/2)
+			drawText(w-runewidth.StringWidth(line), 0, w, line, termbox.ColorDefault, termbox.ColorDefault)
+		} else {
+			drawText(1, h-1, w-1, s.statusLine(time.Now(), w-2), fg, bg)
+		}
+		if s.ShowHistory {
+			renderHistory(s)
+		}
+		if s.ShowOutput {
+			renderOutput(s)
+		}
+		if s.ShowHelp {
+			renderHelp(s)
+		}
 		termbox.Flush()
 	}
 }
+
+// renderRunning draws a striped border around the screen, below the row of
+// glyphs, moving over time to show that a command started at start is running.
+func renderRunning(start, now time.Time) {
+	w, h := termbox.Size()
+	frame := int(now.Sub(start) / (150 * time.Millisecond))
+	stripe := func(x, y int) {
+		if (x+y+frame)/2%2 == 0 {
+			termbox.SetCell(x, y, ' ', termbox.ColorDefault, termbox.ColorBlack)
+		}
+	}
+	for x := 0; x < w; x++ {
+		stripe(x, 1)
+		stripe(x, h-1)
+	}
+	for y := 2; y < h-1; y++ {
+		stripe(0, y)
+		stripe(w-1, y)
+	}
+}
+
+// pane clears and returns the area of the screen used to show output and
+// history, leaving a border in the state color around it when there is enough
+// space.
+func pane() (x0, y0, x1, y1 int) {
+	w, h := termbox.Size()
+	x0, y0, x1, y1 = 0, 1, w, h
+	if w >= 4 && h >= 5 {
+		x0, y0, x1, y1 = 1, 2, w-1, h-1
+	}
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			termbox.SetCell(x, y, ' ', termbox.ColorDefault, termbox.ColorDefault)
+		}
+	}
+	return x0, y0, x1, y1
+}
+
+// renderOutput draws a pane with the output of the selected command execution.
+func renderOutput(s State) {
+	x0, y0, x1, y1 := pane()
+	lines := s.outputLines()
+	height := y1 - y0
+	start := len(lines) - height - s.OutputScroll
+	if start < 0 {
+		start = 0
+	}
+	for i := 0; i < height && start+i < len(lines); i++ {
+		drawText(x0, y0+i, x1, lines[start+i], termbox.ColorDefault, termbox.ColorDefault)
+	}
+}
+
+// renderHistory draws a pane with one line for each command execution, from
+// the newest to the oldest, scrolled so that the selected one is visible.
+func renderHistory(s State) {
+	x0, y0, x1, y1 := pane()
+	height := y1 - y0
+	start := 0
+	if s.Selected >= height {
+		start = s.Selected - height + 1
+	}
+	for i := 0; i < height && start+i < len(s.Results); i++ {
+		n := start + i
+		r := s.Results[len(s.Results)-1-n]
+		fg, bg := termbox.Attribute(s.statusColor(r.Status())), termbox.Attribute(termbox.ColorDefault)
+		if n == s.Selected {
+			fg, bg = termbox.ColorBlack, fg
+			for x := x0; x < x1; x++ {
+				termbox.SetCell(x, y0+i, ' ', fg, bg)
+			}
+		}
+		drawText(x0, y0+i, x1, r.HistoryLine(), fg, bg)
+	}
+}
+
+// renderHelp draws a box with s.Help centered on the screen.
+func renderHelp(s State) {
+	w, h := termbox.Size()
+	width := 0
+	for _, line := range s.Help {
+		if n := runewidth.StringWidth(line); n > width {
+			width = n
+		}
+	}
+	// Leave one column and row of padding on each side, if possible.
+	x0, y0 := (w-width)/2-1, (h-len(s.Help))/2-1
+	if x0 < 0 {
+		x0 = 0
+	}
+	if y0 < 0 {
+		y0 = 0
+	}
+	for y := y0; y < h && y < y0+len(s.Help)+2; y++ {
+		for x := x0; x < w && x < x0+width+2; x++ {
+			termbox.SetCell(x, y, ' ', termbox.ColorDefault, termbox.ColorDefault)
+		}
+	}
+	for i, line := range s.Help {
+		drawText(x0+1, y0+1+i, w, line, termbox.ColorDefault, termbox.ColorDefault)
+	}
+}
+
+// drawText draws s starting at column x in row y, up to column maxX
+// (exclusive). Tabs are expanded and other control characters are skipped. It
+// returns the column after the last drawn character.
+func drawText(x, y, maxX int, s string, fg, bg termbox.Attribute) int {
+	x0 := x
+	for _, r := range s {
+		if r == '\t' {
+			for next := x + 8 - (x-x0)%8; x < next && x < maxX; x++ {
+				termbox.SetCell(x, y, ' ', fg, bg)
+			}
+			continue
+		}
+		if r < ' ' {
+			continue
+		}
+		rw := runewidth.RuneWidth(r)
+		if x+rw > maxX {
+			break
+		}
+		termbox.SetCell(x, y, r, fg, bg)
+		x += rw
+	}
+	return x
+}
@@ -0,0 +1,336 @@
+package redgreen
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// A Report summarizes a session of command executions, such as one recorded in
+// a session log, for instance for the retrospective of a Coding Dojo. The
+// session is red while the state is failing, see State.Status, and green while
+// it is passing.
+type Report struct {
+	Results []RunResult
+	// Start and End are when the first command started and the last one
+	// terminated.
+	Start, End time.Time
+	// Sessions counts the sessions in the report, and Duration is their
+	// total length, excluding the time between them.
+	Sessions int
+	Duration time.Duration
+	// Segments holds the periods of time between consecutive changes of
+	// the state, in order.
+	Segments []Segment
+	// Red and Green are the total time the session was red and green.
+	Red, Green time.Duration
+	// LongestRed is the longest period the session was red.
+	LongestRed Segment
+	// Cycles counts the changes from red to green.
+	Cycles int
+	// Pilots holds a breakdown by pilot, in order of first appearance. It
+	// is empty unless pilots were recorded.
+	Pilots []PilotReport
+}
+
+// A Segment is a period of time during which the session was in the same
+// state.
+type Segment struct {
+	Start, End time.Time
+	Status     Status
+	// Session is the index of the session the segment belongs to.
+	Session int
+	// Runs counts the command executions that ended in the segment.
+	Runs int
+}
+
+// Duration returns the length of the segment.
+func (s Segment) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Red reports whether the segment is red, that is, whether its status is not
+// StatusPass.
+func (s Segment) Red() bool {
+	return s.Status != StatusPass
+}
+
+// A PilotReport summarizes the part of a session led by one pilot.
+type PilotReport struct {
+	Pilot      string
+	Runs       int
+	Red, Green time.Duration
+	// Cycles counts the changes from red to green by runs of the pilot.
+	Cycles int
+}
+
+// ReportMaxGap is the longest time between the end of a command execution and
+// the start of the next one within a session. Longer gaps, such as overnight,
+// split the session in two, so that they are not attributed to any state.
+const ReportMaxGap = time.Hour
+
+// NewReport returns a report of the sessions made of results, in the order the
+// commands terminated. The time between the termination of a command and the
+// next one in the same session is attributed to the state and the pilot after
+// the first one. Sessions are split at gaps longer than ReportMaxGap.
+func NewReport(sessions ...[]RunResult) Report {
+	var split [][]RunResult
+	for _, results := range sessions {
+		for len(results) > 0 {
+			i := 1
+			for i < len(results) && results[i].Start.Sub(results[i-1].End()) <= ReportMaxGap {
+				i++
+			}
+			split = append(split, results[:i])
+			results = results[i:]
+		}
+	}
+	var r Report
+	if len(split) == 0 {
+		return r
+	}
+	for _, results := range split {
+		r.Results = append(r.Results, results...)
+	}
+	last := split[len(split)-1]
+	r.Start, r.End = split[0][0].Start, last[len(last)-1].End()
+	r.Sessions = len(split)
+	pilots := make(map[string]*PilotReport)
+	var order []string
+	pilot := func(name string) *PilotReport {
+		if _, ok := pilots[name]; !ok {
+			pilots[name] = &PilotReport{Pilot: name}
+			order = append(order, name)
+		}
+		return pilots[name]
+	}
+	for session, results := range split {
+		sessionEnd := results[len(results)-1].End()
+		r.Duration += sessionEnd.Sub(results[0].Start)
+		// first is the index of the first segment of the session.
+		first := len(r.Segments)
+		var s State
+		for i, res := range results {
+			s.Results = results[:i+1]
+			status, ok := s.Status()
+			if !ok {
+				// Only cancelled results so far.
+				continue
+			}
+			p := pilot(res.Pilot)
+			p.Runs++
+			end := sessionEnd
+			if i+1 < len(results) {
+				end = results[i+1].End()
+			}
+			d := end.Sub(res.End())
+			if status == StatusPass {
+				p.Green += d
+			} else {
+				p.Red += d
+			}
+			n := len(r.Segments)
+			if n > first && r.Segments[n-1].Status == status {
+				r.Segments[n-1].End = end
+				r.Segments[n-1].Runs++
+				continue
+			}
+			if n > first && r.Segments[n-1].Red() && status == StatusPass {
+				r.Cycles++
+				p.Cycles++
+			}
+			r.Segments = append(r.Segments, Segment{Start: res.End(), End: end, Status: status, Session: session, Runs: 1})
+		}
+	}
+	// Merge consecutive red segments of different statuses when looking
+	// for the longest red period.
+	var red Segment
+	for _, seg := range r.Segments {
+		if red.Runs > 0 && red.Session != seg.Session {
+			red = Segment{}
+		}
+		if seg.Red() {
+			r.Red += seg.Duration()
+			if red.Runs == 0 {
+				red = seg
+			} else {
+				red.End = seg.End
+				red.Runs += seg.Runs
+			}
+			if red.Duration() > r.LongestRed.Duration() || r.LongestRed.Runs == 0 {
+				r.LongestRed = red
+			}
+		} else {
+			r.Green += seg.Duration()
+			red = Segment{}
+		}
+	}
+	if len(order) > 1 || (len(order) == 1 && order[0] != "") {
+		for _, name := range order {
+			r.Pilots = append(r.Pilots, *pilots[name])
+		}
+	}
+	return r
+}
+
+// reportFuncs holds functions available to report templates, both in text and
+// HTML formats.
+var reportFuncs = map[string]interface{}{
+	"duration": func(d time.Duration) string {
+		return formatDuration(d)
+	},
+	"clock": func(t time.Time) string {
+		return t.Format("15:04:05")
+	},
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+	"percent": func(d, total time.Duration) float64 {
+		if total <= 0 {
+			return 0
+		}
+		return float64(d) * 100 / float64(total)
+	},
+	"glyph": func(r RunResult) string {
+		return string(r.Status().Glyph())
+	},
+	"color": func(status Status) string {
+		return status.Color().String()
+	},
+	"command": func(r RunResult) string {
+		if r.Name != "" {
+			return r.Name
+		}
+		return strings.Join(r.Command, " ")
+	},
+	"trigger": func(r RunResult) string {
+		var names []string
+		for _, ev := range r.Trigger {
+			names = append(names, ev.Path)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ", ")
+	},
+	// cell escapes s for use in a cell of a Markdown table.
+	"cell": func(s string) string {
+		return strings.ReplaceAll(s, "|", `\|`)
+	},
+	// tracked returns the time the state of the session was known, that
+	// is, after the first command terminated.
+	"tracked": func(r Report) time.Duration {
+		return r.Red + r.Green
+	},
+}
+
+var markdownReport = template.Must(template.New("markdown").Funcs(reportFuncs).Parse(`# redgreen session report
+{{if .Results}}
+{{date .Start}} – {{date .End}} ({{duration .Duration}}{{if gt .Sessions 1}} in {{.Sessions}} sessions{{end}}), {{len .Results}} runs
+
+## Summary
+
+| | |
+| --- | --- |
+| Time green | {{duration .Green}} ({{printf "%.0f" (percent .Green (tracked .))}}%) |
+| Time red | {{duration .Red}} ({{printf "%.0f" (percent .Red (tracked .))}}%) |
+| Longest red streak | {{duration .LongestRed.Duration}}, {{.LongestRed.Runs}} runs{{if .LongestRed.Runs}}, from {{clock .LongestRed.Start}}{{end}} |
+| Red to green cycles | {{.Cycles}} |
+
+## Timeline
+
+{{range .Results}}{{glyph .}}{{end}}
+
+| Start | Duration | Status |{{if .Pilots}} Pilot |{{end}} Command | Triggered by |
+| --- | --- | --- |{{if .Pilots}} --- |{{end}} --- | --- |
+{{- $pilots := .Pilots}}
+{{range .Results}}| {{clock .Start}} | {{duration .Duration}} | {{glyph .}} {{.Status}} |{{if $pilots}} {{cell .Pilot}} |{{end}} {{cell (command .)}} | {{cell (trigger .)}} |
+{{end}}
+{{- if .Pilots}}
+## Pilots
+
+| Pilot | Runs | Time green | Time red | Cycles |
+| --- | --- | --- | --- | --- |
+{{range .Pilots}}| {{cell .Pilot}} | {{.Runs}} | {{duration .Green}} | {{duration .Red}} | {{.Cycles}} |
+{{end}}{{end}}{{else}}
+No runs were recorded.
+{{end}}`))
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("html").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>redgreen session report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+.timeline { display: flex; height: 2em; margin: 1em 0; }
+.red { background: #d33; } .green { background: #3a3; } .magenta { background: #a3a; }
+.blue { background: #36c; } .cyan { background: #3aa; } .white { background: #aaa; } .yellow { background: #cc3; }
+</style>
+</head>
+<body>
+<h1>redgreen session report</h1>
+{{if .Results}}
+<p>{{date .Start}} – {{date .End}} ({{duration .Duration}}{{if gt .Sessions 1}} in {{.Sessions}} sessions{{end}}), {{len .Results}} runs</p>
+<h2>Summary</h2>
+<table>
+<tr><th>Time green</th><td>{{duration .Green}} ({{printf "%.0f" (percent .Green (tracked .))}}%)</td></tr>
+<tr><th>Time red</th><td>{{duration .Red}} ({{printf "%.0f" (percent .Red (tracked .))}}%)</td></tr>
+<tr><th>Longest red streak</th><td>{{duration .LongestRed.Duration}}, {{.LongestRed.Runs}} runs{{if .LongestRed.Runs}}, from {{clock .LongestRed.Start}}{{end}}</td></tr>
+<tr><th>Red to green cycles</th><td>{{.Cycles}}</td></tr>
+</table>
+<h2>Timeline</h2>
+{{- $tracked := tracked .}}
+<div class="timeline">
+{{- range .Segments}}
+<div class="{{color .Status}}" style="width: {{printf "%.2f" (percent .Duration $tracked)}}%" title="{{clock .Start}} {{.Status}} for {{duration .Duration}}"></div>
+{{- end}}
+</div>
+<table>
+<tr><th>Start</th><th>Duration</th><th>Status</th>{{if .Pilots}}<th>Pilot</th>{{end}}<th>Command</th><th>Triggered by</th></tr>
+{{- $pilots := .Pilots}}
+{{- range .Results}}
+<tr><td>{{clock .Start}}</td><td>{{duration .Duration}}</td><td>{{glyph .}} {{.Status}}</td>{{if $pilots}}<td>{{.Pilot}}</td>{{end}}<td>{{command .}}</td><td>{{trigger .}}</td></tr>
+{{- end}}
+</table>
+{{- if .Pilots}}
+<h2>Pilots</h2>
+<table>
+<tr><th>Pilot</th><th>Runs</th><th>Time green</th><th>Time red</th><th>Cycles</th></tr>
+{{- range .Pilots}}
+<tr><td>{{.Pilot}}</td><td>{{.Runs}}</td><td>{{duration .Green}}</td><td>{{duration .Red}}</td><td>{{.Cycles}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{else}}
+<p>No runs were recorded.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteMarkdown writes the report to w in Markdown format.
+func (r Report) WriteMarkdown(w io.Writer) error {
+	return markdownReport.Execute(w, r)
+}
+
+// WriteHTML writes the report to w as an HTML page.
+func (r Report) WriteHTML(w io.Writer) error {
+	return htmlReport.Execute(w, r)
+}
+
+// WriteReport writes the report to w in format, either "markdown" or "html".
+func (r Report) WriteReport(w io.Writer, format string) error {
+	switch format {
+	case "markdown", "md":
+		return r.WriteMarkdown(w)
+	case "html":
+		return r.WriteHTML(w)
+	default:
+		return fmt.Errorf("unknown report format %q, want markdown or html", format)
+	}
+}
@@ -0,0 +1,83 @@
+package redgreen
+
+import (
+	"fmt"
+	"time"
+)
+
+// A Rotation describes the rotation of pilots in a Coding Dojo: starting at
+// Start, each of Participants is the pilot for Interval, in order, and the next
+// participant is the copilot. The zero value means there is no rotation.
+type Rotation struct {
+	Participants []string
+	Interval     time.Duration
+	Start        time.Time
+}
+
+// DefaultRotationInterval is the default time each pilot leads.
+const DefaultRotationInterval = 5 * time.Minute
+
+// rotationAlert is how long the screen shows an alert after a rotation.
+const rotationAlert = 5 * time.Second
+
+// Enabled reports whether there is a rotation.
+func (r Rotation) Enabled() bool {
+	return len(r.Participants) > 0 && r.Interval > 0
+}
+
+// Turn returns the number of rotations from r.Start until t.
+func (r Rotation) Turn(t time.Time) int {
+	if !r.Enabled() || t.Before(r.Start) {
+		return 0
+	}
+	return int(t.Sub(r.Start) / r.Interval)
+}
+
+// Pilot returns the pilot at time t, or an empty string if there is no
+// rotation.
+func (r Rotation) Pilot(t time.Time) string {
+	if !r.Enabled() {
+		return ""
+	}
+	return r.Participants[r.Turn(t)%len(r.Participants)]
+}
+
+// Copilot returns the copilot at time t, who is the next pilot.
+func (r Rotation) Copilot(t time.Time) string {
+	if !r.Enabled() {
+		return ""
+	}
+	return r.Participants[(r.Turn(t)+1)%len(r.Participants)]
+}
+
+// Left returns the time left at time t until the next rotation.
+func (r Rotation) Left(t time.Time) time.Duration {
+	if !r.Enabled() {
+		return 0
+	}
+	if t.Before(r.Start) {
+		return r.Start.Sub(t) + r.Interval
+	}
+	return r.Interval - t.Sub(r.Start)%r.Interval
+}
+
+// Next rotates pilots at time t, ahead of schedule, so that the copilot at time
+// t becomes the pilot for a full interval.
+func (r *Rotation) Next(t time.Time) {
+	if !r.Enabled() {
+		return
+	}
+	r.Start = t.Add(-time.Duration(r.Turn(t)+1) * r.Interval)
+}
+
+// Alerting reports whether the rotation happened recently at time t, so that
+// participants should be alerted.
+func (r Rotation) Alerting(t time.Time) bool {
+	return r.Enabled() && r.Turn(t) > 0 && r.Interval-r.Left(t) < rotationAlert
+}
+
+// formatCountdown formats a duration as minutes and seconds, rounding up.
+func formatCountdown(d time.Duration) string {
+	s := int((d + time.Second - 1) / time.Second)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
@@ -0,0 +1,189 @@
+package redgreen
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A SessionLog records command executions in a file, one JSON object per line.
+// A log may hold multiple sessions, each starting with a record written by
+// Start.
+type SessionLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// sessionRecord is the representation of a RunResult in a session log. The
+// output of commands is not recorded.
+type sessionRecord struct {
+	Name     string        `json:"name,omitempty"`
+	Command  []string      `json:"command,omitempty"`
+	Trigger  []Event       `json:"trigger,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Pilot    string        `json:"pilot,omitempty"`
+	Partial  bool          `json:"partial,omitempty"`
+	// Status is one of the names of statuses used in configuration files,
+	// such as "pass" or "build".
+	Status string       `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Tests  []TestResult `json:"tests,omitempty"`
+}
+
+// sessionStart is the record that marks the start of a session.
+type sessionStart struct {
+	SessionStart time.Time `json:"session_start"`
+}
+
+// OpenSessionLog opens the session log in path for appending, creating it and
+// its parent directories if needed.
+func OpenSessionLog(path string) (*SessionLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionLog{f: f}, nil
+}
+
+// Start records the start of a new session at time t, so that results
+// appended later are not part of previous sessions.
+func (l *SessionLog) Start(t time.Time) error {
+	return l.write(sessionStart{SessionStart: t})
+}
+
+// Append records r at the end of the log. It is safe to call Append from
+// multiple goroutines.
+func (l *SessionLog) Append(r RunResult) error {
+	rec := sessionRecord{
+		Name:     r.Name,
+		Command:  r.Command,
+		Trigger:  r.Trigger,
+		Start:    r.Start,
+		Duration: r.Duration,
+		Pilot:    r.Pilot,
+		Partial:  r.Partial,
+		Status:   statusName(r.Status()),
+	}
+	if r.Error != nil {
+		rec.Error = r.Error.Error()
+	}
+	for _, t := range r.Tests {
+		t.Output = ""
+		rec.Tests = append(rec.Tests, t)
+	}
+	return l.write(rec)
+}
+
+// write appends v to the log as a line of JSON.
+func (l *SessionLog) write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.f.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the log file.
+func (l *SessionLog) Close() error {
+	return l.f.Close()
+}
+
+// LoadSession reads the results of the last session recorded in the session
+// log in path. The results have the same status as the original ones, but no
+// output.
+func LoadSession(path string) ([]RunResult, error) {
+	sessions, err := LoadSessions(path)
+	if err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+	return sessions[len(sessions)-1], nil
+}
+
+// LoadSessions reads the results of all sessions recorded in the session log in
+// path, in order. Results recorded before the first start of a session, as in
+// logs written before sessions were marked, form a session of their own.
+// Sessions without results are left out.
+func LoadSessions(path string) ([][]RunResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		sessions [][]RunResult
+		results  []RunResult
+	)
+	sc := bufio.NewScanner(f)
+	// Allow for long lists of tests.
+	sc.Buffer(nil, 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec struct {
+			sessionRecord
+			SessionStart *time.Time `json:"session_start"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if rec.SessionStart != nil {
+			if len(results) > 0 {
+				sessions = append(sessions, results)
+			}
+			results = nil
+			continue
+		}
+		status, ok := statusNames[rec.Status]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: unknown status %q", path, line, rec.Status)
+		}
+		r := RunResult{
+			Name:     rec.Name,
+			Command:  rec.Command,
+			Trigger:  rec.Trigger,
+			Start:    rec.Start,
+			Duration: rec.Duration,
+			Pilot:    rec.Pilot,
+			Partial:  rec.Partial,
+			Tests:    rec.Tests,
+		}
+		if status != StatusPass {
+			msg := rec.Error
+			if msg == "" {
+				msg = status.String()
+			}
+			r.Error = &statusError{status: status, msg: msg}
+		}
+		results = append(results, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(results) > 0 {
+		sessions = append(sessions, results)
+	}
+	return sessions, nil
+}
+
+// statusName returns the name of status used in configuration files and
+// session logs.
+func statusName(status Status) string {
+	for name, s := range statusNames {
+		if s == status {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,204 @@
+package redgreen
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rhcarvalho/redgreen/sound"
+)
+
+// A Transition is a change of the state worth announcing with a sound.
+type Transition string
+
+// All transitions.
+const (
+	// TransitionGreen is a change from red to green.
+	TransitionGreen Transition = "green"
+	// TransitionRed is a change from green to red.
+	TransitionRed Transition = "red"
+	// TransitionTimeout is a change to StatusTimeout.
+	TransitionTimeout Transition = "timeout"
+	// TransitionRecovery is a change to green after a long red streak.
+	TransitionRecovery Transition = "recovery"
+)
+
+// DefaultRecoverAfter is the default number of runs in a row with a red state
+// after which turning green is a recovery.
+const DefaultRecoverAfter = 5
+
+// Transition returns the transition caused by the latest result in s, if any.
+// The state is red when it is not StatusPass. Turning green after the state was
+// red for at least recoverAfter runs in a row is a recovery, unless
+// recoverAfter is not positive.
+func (s State) Transition(recoverAfter int) (Transition, bool) {
+	n := len(s.Results)
+	if n == 0 {
+		return "", false
+	}
+	after, ok := s.Status()
+	if !ok {
+		return "", false
+	}
+	before, ok := State{Results: s.Results[:n-1]}.Status()
+	if !ok || before == after {
+		return "", false
+	}
+	switch {
+	case after == StatusTimeout:
+		return TransitionTimeout, true
+	case after == StatusPass:
+		if recoverAfter > 0 && s.redRuns(n-1) >= recoverAfter {
+			return TransitionRecovery, true
+		}
+		return TransitionGreen, true
+	case before == StatusPass:
+		return TransitionRed, true
+	}
+	return "", false
+}
+
+// redRuns returns how many of the first n results, up to and including the
+// nth, left the state red in a row.
+func (s State) redRuns(n int) int {
+	runs := 0
+	for ; n > 0; n-- {
+		status, ok := State{Results: s.Results[:n]}.Status()
+		if !ok || status == StatusPass {
+			break
+		}
+		runs++
+	}
+	return runs
+}
+
+// A Sound is played on a transition. Exactly one of its fields is set.
+type Sound struct {
+	// Sound is the name of a predefined sound, such as "super_nintendo".
+	Sound string `toml:"sound" yaml:"sound"`
+	// File is the path of a WAV file.
+	File string `toml:"file" yaml:"file"`
+	// Say is text to speak aloud.
+	Say string `toml:"say" yaml:"say"`
+	// Melody is a melody to synthesize, see sound.ParseMelody, played at
+	// Tempo with an oscillator of some Waveform at Volume, between 0 and 1.
+	// Zero values mean the defaults of sound.DefaultInstrument and
+	// sound.DefaultTempo.
+	Melody   string  `toml:"melody" yaml:"melody"`
+	Waveform string  `toml:"waveform" yaml:"waveform"`
+	Tempo    int     `toml:"tempo" yaml:"tempo"`
+	Volume   float64 `toml:"volume" yaml:"volume"`
+}
+
+// DefaultSounds returns the sounds played on each transition by default.
+func DefaultSounds() map[Transition]Sound {
+	return map[Transition]Sound{
+		TransitionGreen:    {Sound: "super_nintendo"},
+		TransitionRed:      {Say: "Red"},
+		TransitionTimeout:  {Say: "Timeout"},
+		TransitionRecovery: {Sound: "explosive_counter"},
+	}
+}
+
+// validate reports whether exactly one source of sound is set, and whether it
+// is valid.
+func (snd Sound) validate() error {
+	n := 0
+	for _, v := range []string{snd.Sound, snd.File, snd.Say, snd.Melody} {
+		if v != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("want exactly one of sound, file, say or melody")
+	}
+	if snd.Melody == "" && (snd.Waveform != "" || snd.Tempo != 0 || snd.Volume != 0) {
+		return errors.New("waveform, tempo and volume are only valid with melody")
+	}
+	if snd.Melody != "" {
+		_, err := snd.synthesize()
+		return err
+	}
+	if _, ok := sound.Lookup(snd.Sound); snd.Sound != "" && !ok {
+		return fmt.Errorf("unknown sound %q, want one of %s", snd.Sound, strings.Join(sound.Names(), ", "))
+	}
+	return nil
+}
+
+// Play plays the sound, blocking until it ends.
+func (snd Sound) Play() error {
+	switch {
+	case snd.Sound != "":
+		p, ok := sound.Lookup(snd.Sound)
+		if !ok {
+			return fmt.Errorf("unknown sound %q", snd.Sound)
+		}
+		return p.Play()
+	case snd.File != "":
+		f, err := os.Open(snd.File)
+		if err != nil {
+			return err
+		}
+		sig, err := sound.ReadWAV(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", snd.File, err)
+		}
+		return sound.DefaultSink.Play(sig)
+	case snd.Say != "":
+		return sound.Say(snd.Say)
+	case snd.Melody != "":
+		sig, err := snd.synthesize()
+		if err != nil {
+			return err
+		}
+		return sound.DefaultSink.Play(sig)
+	}
+	return nil
+}
+
+// synthesize returns the signal of the melody of the sound.
+func (snd Sound) synthesize() (sound.Signal, error) {
+	notes, err := sound.ParseMelody(snd.Melody, snd.Tempo)
+	if err != nil {
+		return sound.Signal{}, err
+	}
+	in := sound.DefaultInstrument
+	if snd.Waveform != "" {
+		if in.Waveform, err = sound.ParseWaveform(snd.Waveform); err != nil {
+			return sound.Signal{}, err
+		}
+	}
+	if snd.Volume != 0 {
+		if snd.Volume < 0 || snd.Volume > 1 {
+			return sound.Signal{}, fmt.Errorf("invalid volume %v, want between 0 and 1", snd.Volume)
+		}
+		in.Volume = snd.Volume
+	}
+	return in.Synthesize(sound.DefaultRate, notes...), nil
+}
+
+// players maps the names of players to the sinks that play sounds with them.
+var players = map[string]sound.Sink{
+	"aplay":   sound.Aplay,
+	"paplay":  sound.Paplay,
+	"pw-play": sound.PwPlay,
+	"none":    sound.NullSink{},
+}
+
+// ParsePlayer returns the sink that plays sounds with the named player: aplay,
+// paplay, pw-play or none.
+func ParsePlayer(name string) (sound.Sink, error) {
+	sink, ok := players[name]
+	if !ok {
+		var names []string
+		for name := range players {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown player %q, want one of %s", name, strings.Join(names, ", "))
+	}
+	return sink, nil
+}
@@ -0,0 +1,173 @@
+package redgreen
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// A Status classifies the outcome of a command execution.
+type Status int
+
+// All possible statuses.
+const (
+	// StatusPass means the command succeeded.
+	StatusPass Status = iota
+	// StatusFail means the command failed, typically because of failing
+	// tests.
+	StatusFail
+	// StatusBuildFail means the code under test could not be built.
+	StatusBuildFail
+	// StatusTimeout means the command was killed after its timeout.
+	StatusTimeout
+	// StatusNotFound means the command could not be found.
+	StatusNotFound
+	// StatusSignal means the command was terminated by a signal.
+	StatusSignal
+	// StatusCancelled means the command was cancelled before it
+	// terminated.
+	StatusCancelled
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusPass:
+		return "pass"
+	case StatusFail:
+		return "fail"
+	case StatusBuildFail:
+		return "build failed"
+	case StatusTimeout:
+		return "timeout"
+	case StatusNotFound:
+		return "command not found"
+	case StatusSignal:
+		return "signaled"
+	case StatusCancelled:
+		return "cancelled"
+	default:
+		return "unknown"
+	}
+}
+
+// severity orders statuses from best to worst.
+var severity = map[Status]int{
+	StatusPass:      0,
+	StatusCancelled: 1,
+	StatusFail:      2,
+	StatusTimeout:   3,
+	StatusSignal:    4,
+	StatusBuildFail: 5,
+	StatusNotFound:  6,
+}
+
+// worse reports whether s is worse than t.
+func (s Status) worse(t Status) bool {
+	return severity[s] > severity[t]
+}
+
+// Color returns the color that represents s.
+func (s Status) Color() Color {
+	switch s {
+	case StatusPass:
+		return ColorGreen
+	case StatusBuildFail:
+		return ColorMagenta
+	case StatusTimeout:
+		return ColorBlue
+	case StatusNotFound:
+		return ColorCyan
+	case StatusSignal:
+		return ColorWhite
+	case StatusCancelled:
+		return ColorYellow
+	default:
+		return ColorRed
+	}
+}
+
+// Glyph returns a single character that represents s.
+func (s Status) Glyph() rune {
+	switch s {
+	case StatusPass:
+		return '✔'
+	case StatusBuildFail:
+		return '⚠'
+	case StatusTimeout:
+		return '⧖'
+	case StatusNotFound:
+		return '?'
+	case StatusSignal:
+		return '☠'
+	case StatusCancelled:
+		return '⊘'
+	default:
+		return '✘'
+	}
+}
+
+// Status classifies the outcome of the command execution r.
+func (r RunResult) Status() Status {
+	var (
+		statusErr  *statusError
+		timeoutErr *TimeoutError
+		buildErr   *BuildError
+		execErr    *exec.Error
+		exitErr    *exec.ExitError
+	)
+	switch err := r.Error; {
+	case err == nil:
+		return StatusPass
+	case errors.As(err, &statusErr):
+		return statusErr.status
+	case errors.Is(err, ErrCancelled):
+		return StatusCancelled
+	case errors.As(err, &timeoutErr):
+		return StatusTimeout
+	case errors.As(err, &buildErr):
+		return StatusBuildFail
+	case errors.As(err, &execErr):
+		return StatusNotFound
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return StatusSignal
+		}
+	}
+	return StatusFail
+}
+
+// A TimeoutError is returned when a command is killed because it did not
+// terminate within its timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v: %v", e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// A BuildError is returned when a command fails because the code under test
+// could not be built.
+type BuildError struct {
+	Err error
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("build failed: %v", e.Err)
+}
+
+func (e *BuildError) Unwrap() error { return e.Err }
+
+// A statusError is the error of a result loaded from a session log, which
+// preserves the status of the original error, see LoadSession.
+type statusError struct {
+	status Status
+	msg    string
+}
+
+func (e *statusError) Error() string { return e.msg }
@@ -0,0 +1,116 @@
+package redgreen
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// spinner holds the frames of the animation shown while commands are running.
+var spinner = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// spinnerFrame returns the frame of the spinner animation at time t.
+func spinnerFrame(t time.Time) rune {
+	return spinner[t.UnixNano()/int64(100*time.Millisecond)%int64(len(spinner))]
+}
+
+// Streak returns whether the latest result that was not cancelled passed, and
+// how many results in a row, up to and including it, have the same outcome. It
+// returns zero if there are no such results.
+func (s State) Streak() (pass bool, n int) {
+	for i := len(s.Results) - 1; i >= 0; i-- {
+		status := s.Results[i].Status()
+		if status == StatusCancelled {
+			continue
+		}
+		if n == 0 {
+			pass = status == StatusPass
+		} else if (status == StatusPass) != pass {
+			break
+		}
+		n++
+	}
+	return pass, n
+}
+
+// statusLine returns a line with information about the running and the last
+// command executions, as of now, that fits in width columns. Less important
+// information is left out when there is not enough space.
+func (s State) statusLine(now time.Time, width int) string {
+	// parts holds pieces of information in order of importance.
+	var parts []string
+	var command []string
+	if len(s.Running) > 0 {
+		running := s.Running[0]
+		parts = append(parts, fmt.Sprintf("%c running %s", spinnerFrame(now), formatElapsed(now.Sub(running.Start))))
+		command = running.Spec.Command
+	}
+	if s.Muted {
+		parts = append(parts, "muted")
+	}
+	if s.Rotation.Enabled() {
+		parts = append(parts, fmt.Sprintf("%s → %s %s", s.Rotation.Pilot(now), s.Rotation.Copilot(now), formatCountdown(s.Rotation.Left(now))))
+	}
+	if n := len(s.Results); n > 0 {
+		last := s.Results[n-1]
+		parts = append(parts, fmt.Sprintf("#%d", n))
+		if pass, n := s.Streak(); n > 1 {
+			glyph := StatusFail.Glyph()
+			if pass {
+				glyph = StatusPass.Glyph()
+			}
+			parts = append(parts, fmt.Sprintf("%c×%d", glyph, n))
+		}
+		parts = append(parts,
+			"took "+formatDuration(last.Duration),
+			formatAgo(now.Sub(last.End())),
+		)
+		if command == nil {
+			command = last.Command
+		}
+	}
+	if len(command) > 0 {
+		parts = append(parts, strings.Join(command, " "))
+	}
+	for ; len(parts) > 0; parts = parts[:len(parts)-1] {
+		if line := strings.Join(parts, "  "); runewidth.StringWidth(line) <= width {
+			return line
+		}
+	}
+	return ""
+}
+
+// formatDuration formats d with a precision appropriate to its magnitude.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
+
+// formatElapsed formats the time elapsed since something started, in whole
+// seconds.
+func formatElapsed(d time.Duration) string {
+	return (d / time.Second * time.Second).String()
+}
+
+// formatAgo formats how long ago something happened, given the time elapsed
+// since then.
+func formatAgo(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return "just now"
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", d/time.Second)
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", d/time.Minute)
+	default:
+		return fmt.Sprintf("%dh ago", d/time.Hour)
+	}
+}
@@ -0,0 +1,363 @@
+package redgreen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// A Watcher reports file system events for the paths added to it. Like
+// fsnotify, adding a directory reports events for its direct entries, not
+// recursively.
+type Watcher interface {
+	Add(path string) error
+	Remove(path string) error
+	// Events returns the channel of events. Each event has a single path
+	// and one or more operations.
+	Events() <-chan Event
+	Errors() <-chan error
+	Close() error
+}
+
+// Backend selects a Watcher implementation.
+type Backend int
+
+// All backends.
+const (
+	// BackendAuto uses BackendNotify, falling back to BackendPoll if the
+	// file system notification facilities cannot be used, for instance
+	// because of inotify limits.
+	BackendAuto Backend = iota
+	// BackendNotify uses the file system notification facilities of the
+	// operating system, such as inotify on Linux.
+	BackendNotify
+	// BackendPoll periodically scans the file system for changes.
+	BackendPoll
+)
+
+// DefaultPollInterval is the default interval between scans of BackendPoll.
+const DefaultPollInterval = time.Second
+
+// notifyWatcher is a Watcher backed by fsnotify.
+type notifyWatcher struct {
+	w      *fsnotify.Watcher
+	events chan Event
+}
+
+// newNotifyWatcher returns a Watcher backed by fsnotify.
+func newNotifyWatcher() (*notifyWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	nw := &notifyWatcher{w: w, events: make(chan Event)}
+	go func() {
+		defer close(nw.events)
+		for ev := range w.Events {
+			nw.events <- Event{Path: ev.Name, Op: toOp(ev.Op)}
+		}
+	}()
+	return nw, nil
+}
+
+func (w *notifyWatcher) Add(path string) error    { return w.w.Add(path) }
+func (w *notifyWatcher) Remove(path string) error { return w.w.Remove(path) }
+func (w *notifyWatcher) Events() <-chan Event     { return w.events }
+func (w *notifyWatcher) Errors() <-chan error     { return w.w.Errors }
+
+// Close closes the underlying fsnotify watcher. Any event not yet received from
+// Events is discarded.
+func (w *notifyWatcher) Close() error {
+	err := w.w.Close()
+	// Drain events so that the goroutine translating events returns.
+	for range w.events {
+	}
+	return err
+}
+
+// toOp converts fsnotify operations to Op.
+func toOp(op fsnotify.Op) Op {
+	var o Op
+	for _, x := range []struct {
+		from fsnotify.Op
+		to   Op
+	}{
+		{fsnotify.Create, Create},
+		{fsnotify.Write, Write},
+		{fsnotify.Remove, Remove},
+		{fsnotify.Rename, Rename},
+		{fsnotify.Chmod, Chmod},
+	} {
+		if op&x.from != 0 {
+			o |= x.to
+		}
+	}
+	return o
+}
+
+// pollWatcher is a Watcher that periodically scans the file system.
+type pollWatcher struct {
+	interval time.Duration
+	hash     bool
+	events   chan Event
+	errors   chan error
+	done     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+
+	mu sync.Mutex
+	// watches maps watched paths to the state of the files in them.
+	watches map[string]map[string]fileState
+}
+
+// fileState holds the information used by pollWatcher to detect changes to a
+// file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+	// sum is the hash of the contents of regular files, if enabled.
+	sum []byte
+}
+
+// NewPollWatcher returns a Watcher that scans the watched paths for changes
+// every interval, comparing modification times, sizes and modes of files. If
+// hash is true, the contents of regular files are compared as well, to detect
+// changes on file systems with coarse modification times, at the cost of
+// reading all files in every scan.
+func NewPollWatcher(interval time.Duration, hash bool) Watcher {
+	w := &pollWatcher{
+		interval: interval,
+		hash:     hash,
+		events:   make(chan Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		watches:  make(map[string]map[string]fileState),
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w
+}
+
+func (w *pollWatcher) Add(path string) error {
+	path = filepath.Clean(path)
+	files, err := w.scan(path)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watches[path] = files
+	return nil
+}
+
+func (w *pollWatcher) Remove(path string) error {
+	path = filepath.Clean(path)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.watches[path]; !ok {
+		return errors.New("can't remove non-existent poll watch for: " + path)
+	}
+	delete(w.watches, path)
+	return nil
+}
+
+func (w *pollWatcher) Events() <-chan Event { return w.events }
+func (w *pollWatcher) Errors() <-chan error { return w.errors }
+
+func (w *pollWatcher) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+		w.wg.Wait()
+		close(w.events)
+		close(w.errors)
+	})
+	return nil
+}
+
+// loop scans the watched paths every interval until the watcher is closed.
+func (w *pollWatcher) loop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.done:
+			return
+		}
+		for _, ev := range w.poll() {
+			select {
+			case w.events <- ev:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// poll scans all watched paths and returns events for the changes since the
+// previous scan.
+func (w *pollWatcher) poll() []Event {
+	w.mu.Lock()
+	var paths []string
+	for path := range w.watches {
+		paths = append(paths, path)
+	}
+	w.mu.Unlock()
+	sort.Strings(paths)
+
+	var events []Event
+	for _, path := range paths {
+		files, err := w.scan(path)
+		w.mu.Lock()
+		old, ok := w.watches[path]
+		if !ok {
+			// Removed while scanning.
+			w.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			// Like inotify, stop watching removed paths.
+			delete(w.watches, path)
+			w.mu.Unlock()
+			events = append(events, Event{Path: path, Op: Remove})
+			continue
+		}
+		w.watches[path] = files
+		w.mu.Unlock()
+		events = append(events, diff(old, files)...)
+	}
+	return events
+}
+
+// diff returns events for the changes between two states of the same files.
+func diff(old, new map[string]fileState) []Event {
+	var events []Event
+	for name, s := range new {
+		o, ok := old[name]
+		switch {
+		case !ok:
+			events = append(events, Event{Path: name, Op: Create})
+		case s.mode.IsDir() && o.mode.IsDir():
+			// Like inotify, changes to the entries of a subdirectory are
+			// not reported as changes to the subdirectory itself.
+		case !s.modTime.Equal(o.modTime) || s.size != o.size || !bytes.Equal(s.sum, o.sum):
+			events = append(events, Event{Path: name, Op: Write})
+		case s.mode != o.mode:
+			events = append(events, Event{Path: name, Op: Chmod})
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			events = append(events, Event{Path: name, Op: Remove})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+	return events
+}
+
+// scan returns the state of path if it is a file, or of its entries if it is a
+// directory. Like with inotify, changes to a watched directory itself, other
+// than its removal, are not reported.
+func (w *pollWatcher) scan(path string) (map[string]fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return map[string]fileState{path: w.state(path, info)}, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]fileState, len(entries))
+	for _, info := range entries {
+		name := filepath.Join(path, info.Name())
+		files[name] = w.state(name, info)
+	}
+	return files, nil
+}
+
+// state returns the state of the file name, described by info.
+func (w *pollWatcher) state(name string, info os.FileInfo) fileState {
+	s := fileState{modTime: info.ModTime(), size: info.Size(), mode: info.Mode()}
+	if w.hash && info.Mode().IsRegular() {
+		s.sum = hashFile(name)
+	}
+	return s
+}
+
+// hashFile returns the hash of the contents of the file name, or nil if it
+// cannot be read.
+func hashFile(name string) []byte {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil
+	}
+	return h.Sum(nil)
+}
+
+// contentCache maps file paths to hashes of their contents.
+type contentCache map[string][]byte
+
+// fill records the contents of the relevant files under paths, recursively if
+// recursive is set, so that saving them without modifications is not
+// considered a change.
+func (c contentCache) fill(paths []string, recursive bool, f *filter) {
+	for _, root := range paths {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if path != root && (!recursive || f.ignored(path, true)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() && f.relevant(path, false) {
+				if sum := hashFile(path); sum != nil {
+					c[path] = sum
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// changed reports whether the contents of the file name differ from the last
+// time changed was called for it, or since fill, and records its current
+// contents. Files seen for the first time, directories and files that cannot
+// be read are always considered changed, and files that no longer exist are
+// forgotten, so that they are considered changed when they are created again.
+func (c contentCache) changed(name string) bool {
+	info, err := os.Stat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		delete(c, name)
+		return true
+	}
+	sum := hashFile(name)
+	if sum == nil {
+		delete(c, name)
+		return true
+	}
+	old, ok := c[name]
+	c[name] = sum
+	return !ok || !bytes.Equal(old, sum)
+}
@@ -0,0 +1,112 @@
+package sound
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Predefined is a named sound that is built the first time it is used and
+// then cached. It can be played any number of times, concurrently.
+type Predefined struct {
+	name   string
+	build  func() Signal
+	once   sync.Once
+	signal Signal
+}
+
+// registry maps the names of predefined sounds to sounds.
+var registry = make(map[string]*Predefined)
+
+// Register adds a predefined sound with the given name, replacing any sound
+// with the same name, and returns it. The sound is built by calling build the
+// first time it is used. Register is meant to be called during initialization.
+func Register(name string, build func() Signal) *Predefined {
+	p := &Predefined{name: name, build: build}
+	registry[name] = p
+	return p
+}
+
+// Lookup returns the predefined sound with the given name.
+func Lookup(name string) (*Predefined, bool) {
+	p, ok := registry[name]
+	return p, ok
+}
+
+// Names returns the names of all predefined sounds, in alphabetical order.
+func Names() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Name returns the name of the sound.
+func (p *Predefined) Name() string {
+	return p.name
+}
+
+// Signal returns the signal of the sound, building it on first use. The
+// samples are shared by all callers and must not be modified.
+func (p *Predefined) Signal() Signal {
+	p.once.Do(func() {
+		p.signal = p.build()
+	})
+	return p.signal
+}
+
+// Play plays the sound on DefaultSink, blocking until it ends.
+func (p *Predefined) Play() error {
+	return DefaultSink.Play(p.Signal())
+}
+
+// addBeep appends to s a square wave beep of the given frequency and duration.
+func (s *Signal) addBeep(frequency float64, duration time.Duration) {
+	s.Samples = append(s.Samples, beep(s.Rate, frequency, duration)...)
+}
+
+// Some predefined sounds, sampled at the rate of DefaultFormat.
+var (
+	ExplosiveCounter = Register("explosive_counter", func() Signal {
+		s := Signal{Rate: DefaultFormat.SampleRate}
+		for i := 6; i < 85; i++ {
+			s.addBeep(37*float64(i), 50*time.Millisecond)
+			s.addBeep(1, 70*time.Nanosecond)
+		}
+		s.addBeep(37, 2000)
+		return s
+	})
+
+	SuperNintendo = Register("super_nintendo", func() Signal {
+		s := Signal{Rate: DefaultFormat.SampleRate}
+		for i := 1; i < 5; i++ {
+			for j := 1; j < 5; j++ {
+				s.addBeep(100*float64(i*j), 50*time.Millisecond)
+				s.addBeep(1, 20*time.Nanosecond)
+			}
+		}
+		return s
+	})
+
+	PhoneRing = Register("phone_ring", func() Signal {
+		s := Signal{Rate: DefaultFormat.SampleRate}
+		for i := 1; i < 5; i++ {
+			for j := 1; j < 5; j++ {
+				s.addBeep(700+40*float64(i), 200*time.Millisecond)
+				s.addBeep(1, 1*time.Second)
+			}
+		}
+		return s
+	})
+
+	Fluid = Register("fluid", func() Signal {
+		s := Signal{Rate: DefaultFormat.SampleRate}
+		for i := float64(0); i < math.Pi/2; i += 0.1 {
+			s.addBeep(200+100*math.Sin(i), 200*time.Millisecond)
+		}
+		return s
+	})
+)
@@ -0,0 +1,87 @@
+package sound
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// A Sink plays signals, or stores them.
+type Sink interface {
+	Play(s Signal) error
+}
+
+// A CommandSink plays signals by writing them as WAV files to the standard
+// input of a command, such as aplay.
+type CommandSink struct {
+	Command []string
+	Format  Format
+}
+
+// Sinks that play signals with common command-line players.
+var (
+	// Aplay plays signals with the ALSA player.
+	Aplay = CommandSink{Command: []string{"aplay", "-q"}}
+	// Paplay plays signals with the PulseAudio player.
+	Paplay = CommandSink{Command: []string{"paplay"}}
+	// PwPlay plays signals with the PipeWire player.
+	PwPlay = CommandSink{Command: []string{"pw-play", "-"}}
+)
+
+// Play plays s, blocking until it ends.
+func (c CommandSink) Play(s Signal) error {
+	var buf bytes.Buffer
+	if err := WriteWAV(&buf, s, c.Format); err != nil {
+		return err
+	}
+	cmd := exec.Command(c.Command[0], c.Command[1:]...)
+	cmd.Stdin = &buf
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %v: %s", c.Command[0], err, msg)
+		}
+		return fmt.Errorf("%s: %v", c.Command[0], err)
+	}
+	return nil
+}
+
+// A FileSink writes each signal it plays to the WAV file in Path, replacing
+// its contents.
+type FileSink struct {
+	Path   string
+	Format Format
+}
+
+// Play writes s to the file.
+func (f FileSink) Play(s Signal) error {
+	var buf bytes.Buffer
+	if err := WriteWAV(&buf, s, f.Format); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.Path, buf.Bytes(), 0644)
+}
+
+// A NullSink discards signals, for instance in tests.
+type NullSink struct{}
+
+// Play does nothing.
+func (NullSink) Play(s Signal) error {
+	return nil
+}
+
+// DefaultSink is where Play and Say play sounds. It is the first of Aplay,
+// Paplay and PwPlay whose command is installed, or a NullSink if none is.
+var DefaultSink = detectSink()
+
+// detectSink returns the first sink that plays signals with an installed
+// command, or a NullSink.
+func detectSink() Sink {
+	for _, c := range []CommandSink{Aplay, Paplay, PwPlay} {
+		if _, err := exec.LookPath(c.Command[0]); err == nil {
+			return c
+		}
+	}
+	return NullSink{}
+}
@@ -3,12 +3,20 @@ package sound
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
 )
 
 func Example() {
 	sounds := []struct {
-		name string
-		buf  bytes.Buffer
+		name  string
+		sound *Predefined
 	}{
 		{"Explosive counter", ExplosiveCounter},
 		{"Super Nintendo", SuperNintendo},
@@ -18,7 +26,201 @@ func Example() {
 	Say(fmt.Sprintf("Hello, I am going to play %d example sounds!", len(sounds)))
 	for i, snd := range sounds {
 		Say(fmt.Sprintf("%d, %s:", i+1, snd.name))
-		Play(&snd.buf)
+		snd.sound.Play()
 	}
 	// Output:
 }
+
+func TestWAV(t *testing.T) {
+	s := Signal{Rate: 8000, Samples: []float64{0, 0.5, -0.5, 1, -1}}
+	for _, depth := range []int{8, 16, 24, 32} {
+		var buf bytes.Buffer
+		if err := WriteWAV(&buf, s, Format{BitDepth: depth}); err != nil {
+			t.Fatalf("WriteWAV(%d bits) error = %v", depth, err)
+		}
+		if got, want := buf.Len(), 44+len(s.Samples)*depth/8; got != want {
+			t.Errorf("WriteWAV(%d bits) wrote %d bytes, want %d", depth, got, want)
+		}
+		got, err := ReadWAV(&buf)
+		if err != nil {
+			t.Fatalf("ReadWAV(%d bits) error = %v", depth, err)
+		}
+		if got.Rate != s.Rate || len(got.Samples) != len(s.Samples) {
+			t.Fatalf("ReadWAV(%d bits) = %d samples at %d Hz, want %d at %d Hz", depth, len(got.Samples), got.Rate, len(s.Samples), s.Rate)
+		}
+		for i, v := range got.Samples {
+			if math.Abs(v-s.Samples[i]) > 0.01 {
+				t.Errorf("ReadWAV(%d bits) sample %d = %v, want %v", depth, i, v, s.Samples[i])
+			}
+		}
+	}
+}
+
+func TestWAVResample(t *testing.T) {
+	s := Signal{Rate: 4000, Samples: []float64{0, 1, 0, -1}}
+	var buf bytes.Buffer
+	if err := WriteWAV(&buf, s, Format{SampleRate: 8000, BitDepth: 16}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadWAV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Rate != 8000 || len(got.Samples) != 8 || got.Duration() != s.Duration() {
+		t.Errorf("ReadWAV() = %d samples at %d Hz, want 8 at 8000 Hz", len(got.Samples), got.Rate)
+	}
+}
+
+func TestWAVErrors(t *testing.T) {
+	if err := WriteWAV(ioutil.Discard, Signal{Rate: 8000}, Format{BitDepth: 12}); err == nil {
+		t.Error("WriteWAV(12 bits) = nil, want not nil")
+	}
+	for _, b := range []string{"", "RIFF", "RIFF\x04\x00\x00\x00WAVE", "RIFF\x0c\x00\x00\x00WAVEdata\x00\x00\x00\x00"} {
+		if _, err := ReadWAV(bytes.NewBufferString(b)); err == nil {
+			t.Errorf("ReadWAV(%q) = nil error, want not nil", b)
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "beep.wav")
+	s := Signal{Rate: 8000, Samples: beep(8000, 440, 100*time.Millisecond)}
+	if err := (FileSink{Path: path, Format: Format{SampleRate: 16000, BitDepth: 16}}).Play(s); err != nil {
+		t.Fatalf("Play() error = %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := ReadWAV(f)
+	if err != nil {
+		t.Fatalf("ReadWAV() error = %v", err)
+	}
+	if got.Rate != 16000 || got.Duration() != s.Duration() {
+		t.Errorf("ReadWAV() = %v at %d Hz, want %v at 16000 Hz", got.Duration(), got.Rate, s.Duration())
+	}
+}
+
+func TestPlay(t *testing.T) {
+	defer func(sink Sink) { DefaultSink = sink }(DefaultSink)
+	DefaultSink = NullSink{}
+	buf := bytes.NewBuffer(DefaultFormat.encode(Signal{Rate: 8000, Samples: beep(8000, 440, 100*time.Millisecond)}))
+	if err := Play(buf); err != nil {
+		t.Errorf("Play() error = %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Play() left %d bytes in buffer, want 0", buf.Len())
+	}
+	if err := Play(bytes.NewBufferString("RIFF")); err == nil {
+		t.Error("Play(invalid WAV) = nil, want not nil")
+	}
+}
+
+func TestParseMelody(t *testing.T) {
+	notes, err := ParseMelody("C4:8 E4:8 G4:4 R:4 A4:2. Bb C#5:1", 0)
+	if err != nil {
+		t.Fatalf("ParseMelody() error = %v", err)
+	}
+	want := []struct {
+		frequency float64
+		duration  time.Duration
+	}{
+		{261.63, 250 * time.Millisecond},
+		{329.63, 250 * time.Millisecond},
+		{392.00, 500 * time.Millisecond},
+		{0, 500 * time.Millisecond},
+		{440.00, 1500 * time.Millisecond},
+		{466.16, 500 * time.Millisecond},
+		{554.37, 2 * time.Second},
+	}
+	if len(notes) != len(want) {
+		t.Fatalf("ParseMelody() = %d notes, want %d", len(notes), len(want))
+	}
+	for i, n := range notes {
+		if math.Abs(n.Frequency-want[i].frequency) > 0.01 || n.Duration != want[i].duration {
+			t.Errorf("note %d = %.2f Hz for %v, want %.2f Hz for %v", i, n.Frequency, n.Duration, want[i].frequency, want[i].duration)
+		}
+	}
+	if notes, _ := ParseMelody("C4:4", 60); notes[0].Duration != time.Second {
+		t.Errorf("ParseMelody(C4:4, 60) = %v, want 1s", notes[0].Duration)
+	}
+	for _, s := range []string{"H4", "C4:0", "C4:x", "C10", "C#x", ":4"} {
+		if _, err := ParseMelody(s, 0); err == nil {
+			t.Errorf("ParseMelody(%q) = nil error, want not nil", s)
+		}
+	}
+}
+
+func TestSynthesize(t *testing.T) {
+	for name, w := range waveforms {
+		in := Instrument{Waveform: w, Envelope: DefaultEnvelope, Volume: 0.5}
+		s := in.Synthesize(8000, Note{Frequency: 440, Duration: 100 * time.Millisecond}, Note{Duration: 50 * time.Millisecond})
+		if got, want := s.Duration(), 150*time.Millisecond; got != want {
+			t.Errorf("%s: Duration() = %v, want %v", name, got, want)
+		}
+		var max float64
+		for _, v := range s.Samples[:800] {
+			max = math.Max(max, math.Abs(v))
+		}
+		if max < 0.3 || max > 0.5 {
+			t.Errorf("%s: peak = %v, want between 0.3 and 0.5", name, max)
+		}
+		for i, v := range s.Samples[800:] {
+			if v != 0 {
+				t.Fatalf("%s: sample %d of rest = %v, want 0", name, i, v)
+			}
+		}
+		if s.Samples[0] != 0 {
+			t.Errorf("%s: first sample = %v, want 0 with attack", name, s.Samples[0])
+		}
+	}
+	// The zero value plays a sine wave at zero volume.
+	s := Instrument{}.Synthesize(8000, Note{Frequency: 440, Duration: 10 * time.Millisecond})
+	if got, want := len(s.Samples), 80; got != want {
+		t.Errorf("zero Instrument: len(Samples) = %d, want %d", got, want)
+	}
+	s = Instrument{Volume: 1}.Synthesize(8000, Note{Frequency: 440, Duration: 10 * time.Millisecond})
+	if got, want := s.Samples[1], Sine(440.0/8000); got != want {
+		t.Errorf("Instrument without Waveform: sample 1 = %v, want %v", got, want)
+	}
+}
+
+func TestPredefined(t *testing.T) {
+	defer func(sink Sink) { DefaultSink = sink }(DefaultSink)
+	DefaultSink = NullSink{}
+	if got, want := Names(), []string{"explosive_counter", "fluid", "phone_ring", "super_nintendo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %q, want %q", got, want)
+	}
+	p, ok := Lookup("super_nintendo")
+	if !ok || p != SuperNintendo || p.Name() != "super_nintendo" {
+		t.Fatalf("Lookup(super_nintendo) = %v, %v, want SuperNintendo", p, ok)
+	}
+	if _, ok := Lookup("trumpet"); ok {
+		t.Error("Lookup(trumpet) = true, want false")
+	}
+	// Play the sound concurrently, and check that it can be replayed.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Play(); err != nil {
+				t.Errorf("Play() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	s := p.Signal()
+	if len(s.Samples) == 0 || s.Rate != DefaultFormat.SampleRate {
+		t.Fatalf("Signal() = %d samples at %d Hz, want some at %d Hz", len(s.Samples), s.Rate, DefaultFormat.SampleRate)
+	}
+	if again := p.Signal(); &again.Samples[0] != &s.Samples[0] {
+		t.Error("Signal() built the sound again, want cached")
+	}
+}
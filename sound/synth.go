@@ -0,0 +1,208 @@
+package sound
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Waveform is the shape of the signal of an oscillator: it returns the value
+// of the signal, between -1 and 1, at a phase between 0 and 1 of a period.
+type Waveform func(phase float64) float64
+
+// Waveforms of common oscillators.
+var (
+	Sine Waveform = func(phase float64) float64 {
+		return math.Sin(2 * math.Pi * phase)
+	}
+	Square Waveform = func(phase float64) float64 {
+		if phase < 0.5 {
+			return 1
+		}
+		return -1
+	}
+	Triangle Waveform = func(phase float64) float64 {
+		return 1 - 4*math.Abs(phase-0.5)
+	}
+	Sawtooth Waveform = func(phase float64) float64 {
+		return 2*phase - 1
+	}
+)
+
+// waveforms maps the names of waveforms to waveforms.
+var waveforms = map[string]Waveform{
+	"sine":     Sine,
+	"square":   Square,
+	"triangle": Triangle,
+	"sawtooth": Sawtooth,
+}
+
+// ParseWaveform returns the waveform named sine, square, triangle or sawtooth.
+func ParseWaveform(name string) (Waveform, error) {
+	w, ok := waveforms[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown waveform %q, want sine, square, triangle or sawtooth", name)
+	}
+	return w, nil
+}
+
+// An Envelope shapes the volume of a note over time: the volume rises from
+// zero to full during Attack, falls to the Sustain level, between 0 and 1,
+// during Decay, and falls back to zero during Release, at the end of the note.
+// The zero value keeps the volume full.
+type Envelope struct {
+	Attack, Decay time.Duration
+	Sustain       float64
+	Release       time.Duration
+}
+
+// DefaultEnvelope is an envelope suitable for short notes.
+var DefaultEnvelope = Envelope{
+	Attack:  10 * time.Millisecond,
+	Decay:   50 * time.Millisecond,
+	Sustain: 0.7,
+	Release: 30 * time.Millisecond,
+}
+
+// gain returns the volume at time t of a note of length d.
+func (e Envelope) gain(t, d time.Duration) float64 {
+	if e == (Envelope{}) {
+		return 1
+	}
+	var g float64
+	switch {
+	case t < e.Attack:
+		g = float64(t) / float64(e.Attack)
+	case t < e.Attack+e.Decay:
+		g = 1 - (1-e.Sustain)*float64(t-e.Attack)/float64(e.Decay)
+	default:
+		g = e.Sustain
+	}
+	if left := d - t; left < e.Release {
+		g *= float64(left) / float64(e.Release)
+	}
+	return g
+}
+
+// A Note is a tone of some Frequency, in Hz, played for Duration. A note of
+// zero frequency is a rest.
+type Note struct {
+	Frequency float64
+	Duration  time.Duration
+}
+
+// An Instrument synthesizes notes with an oscillator of some Waveform, shaped
+// by an Envelope and scaled by Volume, between 0 and 1. A nil Waveform is a
+// Sine.
+type Instrument struct {
+	Waveform Waveform
+	Envelope Envelope
+	Volume   float64
+}
+
+// DefaultInstrument is a soft sine wave instrument.
+var DefaultInstrument = Instrument{Waveform: Sine, Envelope: DefaultEnvelope, Volume: 0.5}
+
+// DefaultRate is the sample rate of synthesized signals.
+const DefaultRate = 22050
+
+// Synthesize returns the signal of the notes played one after the other,
+// sampled rate times per second.
+func (in Instrument) Synthesize(rate int, notes ...Note) Signal {
+	w := in.Waveform
+	if w == nil {
+		w = Sine
+	}
+	s := Signal{Rate: rate}
+	for _, n := range notes {
+		count := int(int64(n.Duration) * int64(rate) / int64(time.Second))
+		for i := 0; i < count; i++ {
+			if n.Frequency <= 0 {
+				s.Samples = append(s.Samples, 0)
+				continue
+			}
+			t := time.Duration(i) * time.Second / time.Duration(rate)
+			_, phase := math.Modf(float64(i) * n.Frequency / float64(rate))
+			s.Samples = append(s.Samples, in.Volume*in.Envelope.gain(t, n.Duration)*w(phase))
+		}
+	}
+	return s
+}
+
+// DefaultTempo is the default tempo of melodies, in quarter notes per minute.
+const DefaultTempo = 120
+
+// semitones maps the names of notes to their distance from C.
+var semitones = map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+
+// ParseMelody parses a melody written as notes separated by spaces, played at
+// tempo quarter notes per minute. Each note is a name from A to G, optionally
+// followed by # or b, an octave and, after a colon, a length: 1 for a whole
+// note, 2 for a half note, 4 for a quarter note, and so on, with an optional
+// dot to make it half as long again. Rests are written as R. For instance,
+// "C4:8 E4:8 G4:4 R:4 C5:2." is an arpeggio followed by a long note. The
+// length defaults to a quarter note and the octave to 4, where A4 is 440 Hz.
+func ParseMelody(s string, tempo int) ([]Note, error) {
+	if tempo <= 0 {
+		tempo = DefaultTempo
+	}
+	whole := 4 * time.Minute / time.Duration(tempo)
+	var notes []Note
+	for _, field := range strings.Fields(s) {
+		name, length := field, "4"
+		if i := strings.IndexByte(field, ':'); i >= 0 {
+			name, length = field[:i], field[i+1:]
+		}
+		dotted := strings.HasSuffix(length, ".")
+		length = strings.TrimSuffix(length, ".")
+		n, err := strconv.Atoi(length)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid length in note %q", field)
+		}
+		note := Note{Duration: whole / time.Duration(n)}
+		if dotted {
+			note.Duration += note.Duration / 2
+		}
+		if note.Frequency, err = parsePitch(name); err != nil {
+			return nil, fmt.Errorf("invalid note %q: %v", field, err)
+		}
+		notes = append(notes, note)
+	}
+	return notes, nil
+}
+
+// parsePitch returns the frequency of the note named s, such as "A4", "C#5"
+// or "Bb", or zero for a rest, "R".
+func parsePitch(s string) (float64, error) {
+	if strings.EqualFold(s, "R") {
+		return 0, nil
+	}
+	if s == "" {
+		return 0, fmt.Errorf("missing name")
+	}
+	semitone, ok := semitones[strings.ToUpper(s)[0]]
+	if !ok {
+		return 0, fmt.Errorf("unknown name %q", s[:1])
+	}
+	s = s[1:]
+	switch {
+	case strings.HasPrefix(s, "#"):
+		semitone++
+		s = s[1:]
+	case strings.HasPrefix(s, "b"):
+		semitone--
+		s = s[1:]
+	}
+	octave := 4
+	if s != "" {
+		var err error
+		if octave, err = strconv.Atoi(s); err != nil || octave < 0 || octave > 9 {
+			return 0, fmt.Errorf("invalid octave %q", s)
+		}
+	}
+	// MIDI note number 69 is A4.
+	midi := 12*(octave+1) + semitone
+	return 440 * math.Pow(2, float64(midi-69)/12), nil
+}
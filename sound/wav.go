@@ -0,0 +1,234 @@
+package sound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+// A Signal is a mono audio signal: a sequence of samples between -1 and 1,
+// taken Rate times per second.
+type Signal struct {
+	Rate    int
+	Samples []float64
+}
+
+// Duration returns the length of the signal.
+func (s Signal) Duration() time.Duration {
+	if s.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(len(s.Samples)) * time.Second / time.Duration(s.Rate)
+}
+
+// resample returns the signal sampled rate times per second, picking the
+// nearest sample.
+func (s Signal) resample(rate int) Signal {
+	if s.Rate == rate || s.Rate <= 0 {
+		return s
+	}
+	n := int(int64(len(s.Samples)) * int64(rate) / int64(s.Rate))
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = s.Samples[int(int64(i)*int64(s.Rate)/int64(rate))]
+	}
+	return Signal{Rate: rate, Samples: samples}
+}
+
+// A Format describes how samples are encoded: SampleRate samples per second,
+// with BitDepth bits each. Samples of 8 bits are unsigned, others are signed.
+// Zero fields mean the rate of the encoded signal and 16 bits.
+type Format struct {
+	SampleRate int
+	BitDepth   int
+}
+
+// DefaultFormat is the format of the predefined sounds: 8-bit samples taken at
+// 8 kHz.
+var DefaultFormat = Format{SampleRate: 8000, BitDepth: 8}
+
+// of returns f with zero fields set for the signal s.
+func (f Format) of(s Signal) Format {
+	if f.SampleRate == 0 {
+		f.SampleRate = s.Rate
+	}
+	if f.BitDepth == 0 {
+		f.BitDepth = 16
+	}
+	return f
+}
+
+func (f Format) validate() error {
+	switch {
+	case f.SampleRate <= 0:
+		return fmt.Errorf("invalid sample rate %d", f.SampleRate)
+	case f.BitDepth != 8 && f.BitDepth != 16 && f.BitDepth != 24 && f.BitDepth != 32:
+		return fmt.Errorf("unsupported bit depth %d, want 8, 16, 24 or 32", f.BitDepth)
+	}
+	return nil
+}
+
+// encode returns the samples of s encoded as little-endian PCM data in format
+// f, which must be valid.
+func (f Format) encode(s Signal) []byte {
+	s = s.resample(f.SampleRate)
+	size := f.BitDepth / 8
+	b := make([]byte, len(s.Samples)*size)
+	max := float64(int64(1)<<(f.BitDepth-1) - 1)
+	for i, v := range s.Samples {
+		v = math.Max(-1, math.Min(1, v))
+		if f.BitDepth == 8 {
+			b[i] = byte(128 + math.Round(v*max))
+			continue
+		}
+		x := uint32(int32(math.Round(v * max)))
+		for j := 0; j < size; j++ {
+			b[i*size+j] = byte(x >> (8 * j))
+		}
+	}
+	return b
+}
+
+// decode returns the signal encoded in b as little-endian PCM data in format f,
+// which must be valid, averaging the given number of interleaved channels.
+func (f Format) decode(b []byte, channels int) Signal {
+	size := f.BitDepth / 8
+	frame := size * channels
+	s := Signal{Rate: f.SampleRate, Samples: make([]float64, len(b)/frame)}
+	max := float64(int64(1)<<(f.BitDepth-1) - 1)
+	for i := range s.Samples {
+		var sum float64
+		for c := 0; c < channels; c++ {
+			p := b[i*frame+c*size:]
+			if f.BitDepth == 8 {
+				sum += (float64(p[0]) - 128) / max
+				continue
+			}
+			var x uint32
+			for j := 0; j < size; j++ {
+				x |= uint32(p[j]) << (8 * j)
+			}
+			// Sign-extend the sample.
+			shift := uint(32 - f.BitDepth)
+			sum += float64(int32(x<<shift)>>shift) / max
+		}
+		s.Samples[i] = math.Max(-1, sum/float64(channels))
+	}
+	return s
+}
+
+// wavHeader is the header of a WAV file with PCM data, which follows it.
+type wavHeader struct {
+	RIFF          [4]byte
+	Size          uint32
+	WAVE          [4]byte
+	Fmt           [4]byte
+	FmtSize       uint32
+	AudioFormat   uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+	Data          [4]byte
+	DataSize      uint32
+}
+
+// WriteWAV writes the signal s to w as a mono WAV file in format f.
+func WriteWAV(w io.Writer, s Signal, f Format) error {
+	f = f.of(s)
+	if err := f.validate(); err != nil {
+		return err
+	}
+	data := f.encode(s)
+	h := wavHeader{
+		RIFF:          [4]byte{'R', 'I', 'F', 'F'},
+		Size:          uint32(36 + len(data)),
+		WAVE:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		AudioFormat:   1, // PCM
+		Channels:      1,
+		SampleRate:    uint32(f.SampleRate),
+		ByteRate:      uint32(f.SampleRate * f.BitDepth / 8),
+		BlockAlign:    uint16(f.BitDepth / 8),
+		BitsPerSample: uint16(f.BitDepth),
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      uint32(len(data)),
+	}
+	if err := binary.Write(w, binary.LittleEndian, h); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// ReadWAV reads a WAV file with PCM data from r. Multiple channels are mixed
+// into one.
+func ReadWAV(r io.Reader) (Signal, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Signal{}, err
+	}
+	if len(b) < 12 || string(b[:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return Signal{}, errors.New("not a WAV file")
+	}
+	var (
+		f        Format
+		channels int
+	)
+	for b = b[12:]; len(b) >= 8; {
+		id, size := string(b[:4]), binary.LittleEndian.Uint32(b[4:8])
+		b = b[8:]
+		// Streams may not know the size of their data in advance.
+		if int64(size) > int64(len(b)) {
+			size = uint32(len(b))
+		}
+		chunk := b[:size]
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return Signal{}, errors.New("invalid WAV format chunk")
+			}
+			if format := binary.LittleEndian.Uint16(chunk); format != 1 {
+				return Signal{}, fmt.Errorf("unsupported WAV audio format %d, want PCM", format)
+			}
+			channels = int(binary.LittleEndian.Uint16(chunk[2:]))
+			f.SampleRate = int(binary.LittleEndian.Uint32(chunk[4:]))
+			f.BitDepth = int(binary.LittleEndian.Uint16(chunk[14:]))
+			if err := f.validate(); err != nil {
+				return Signal{}, err
+			}
+			if channels < 1 {
+				return Signal{}, fmt.Errorf("invalid number of channels %d", channels)
+			}
+		case "data":
+			if channels == 0 {
+				return Signal{}, errors.New("WAV data before format chunk")
+			}
+			return f.decode(chunk, channels), nil
+		}
+		// Chunks are padded to an even size.
+		b = b[size:]
+		if size%2 == 1 && len(b) > 0 {
+			b = b[1:]
+		}
+	}
+	return Signal{}, errors.New("WAV file has no data")
+}
+
+// decodeBuffer returns the signal in buf, either a WAV file or raw samples in
+// DefaultFormat.
+func decodeBuffer(buf *bytes.Buffer) (Signal, error) {
+	if bytes.HasPrefix(buf.Bytes(), []byte("RIFF")) {
+		return ReadWAV(buf)
+	}
+	s := DefaultFormat.decode(buf.Bytes(), 1)
+	buf.Reset()
+	return s, nil
+}